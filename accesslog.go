@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// accessLogFormats are the formats understood by "awswhois log". The default
+// formats of nginx and Apache are both the NCSA common or combined one, which
+// starts the same way.
+var accessLogFormats = []string{"nginx", "apache", "combined", "common"}
+
+// accessLogRegexp matches the start of a line in the NCSA common log format,
+// which the combined one extends with the referer and the user agent:
+//
+//	52.94.76.10 - - [10/Oct/2024:13:55:36 +0000] "GET / HTTP/1.1" 200 2326 ...
+var accessLogRegexp = regexp.MustCompile(`^(\S+) \S+ \S+ \[[^\]]*\] "(?:[^"\\]|\\.)*" \d{3} (\d+|-)`)
+
+// clientTraffic is what a client IP of an access log sent.
+type clientTraffic struct {
+	IP       string
+	Requests int
+	Bytes    int64
+}
+
+// parseAccessLog sums the requests and the bytes sent back of each client IP
+// of an access log, in the order they first appear. It also returns the
+// number of lines that aren't in the format.
+func parseAccessLog(r io.Reader) (clients []*clientTraffic, skipped int, err error) {
+	byIP := make(map[string]*clientTraffic)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m := accessLogRegexp.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			if strings.TrimSpace(scanner.Text()) != "" {
+				skipped++
+			}
+			continue
+		}
+		c, ok := byIP[m[1]]
+		if !ok {
+			c = &clientTraffic{IP: m[1]}
+			byIP[m[1]] = c
+			clients = append(clients, c)
+		}
+		c.Requests++
+		if n, err := strconv.ParseInt(m[2], 10, 64); err == nil {
+			c.Bytes += n
+		}
+	}
+	return clients, skipped, scanner.Err()
+}
+
+// runAccessLog implements "awswhois log", which tells how much of the traffic
+// of a web server comes from AWS: the requests and bytes of the client IPs of
+// an access log are summed by AWS region and service, and --per-ip lists
+// them.
+func runAccessLog(args []string, source rangesSource, opts lookupOptions, concurrency int, in io.Reader, out, errOut io.Writer) error {
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	format := fs.String("format", "combined", "the format of the access log: "+strings.Join(accessLogFormats, ", "))
+	perIP := fs.Bool("per-ip", false, "also list the client IPs in AWS with their requests and bytes")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] log [--format nginx|apache|combined|common] [--per-ip] [<access.log>]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return usagef("log takes at most one file, and reads stdin otherwise")
+	}
+	if !slices.Contains(accessLogFormats, *format) {
+		return usagef("unknown format %q, must be one of: %s", *format, strings.Join(accessLogFormats, ", "))
+	}
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	clients, skipped, err := parseAccessLog(in)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		slog.Warn("skipped lines that aren't in the format", "format", *format, "lines", skipped)
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	// The client IPs are only looked up, even when the log holds hostnames
+	// because of HostnameLookups.
+	opts.NoResolve = true
+	inputs := make([]TargetLine, len(clients))
+	for i, c := range clients {
+		inputs[i] = TargetLine{Num: i + 1, Text: c.IP}
+	}
+	outcome := lookupAll(context.Background(), inputs, concurrency, ranges, opts, errOut)
+
+	type group struct {
+		Region, Service string
+		IPs, Requests   int
+		Bytes           int64
+	}
+	var total, fromAWS clientTraffic
+	var groups []*group
+	type awsClient struct {
+		*clientTraffic
+		Region, Service string
+	}
+	var awsClients []awsClient
+	for i, c := range clients {
+		total.Requests += c.Requests
+		total.Bytes += c.Bytes
+		o := outcome(i)
+		if o.Err != nil {
+			continue
+		}
+		region, ok := printValue(o.Result, "region")
+		if !ok {
+			continue
+		}
+		service, _ := printValue(o.Result, "service")
+		fromAWS.Requests += c.Requests
+		fromAWS.Bytes += c.Bytes
+		awsClients = append(awsClients, awsClient{c, region, service})
+
+		j := slices.IndexFunc(groups, func(g *group) bool { return g.Region == region && g.Service == service })
+		if j < 0 {
+			groups = append(groups, &group{Region: region, Service: service})
+			j = len(groups) - 1
+		}
+		groups[j].IPs++
+		groups[j].Requests += c.Requests
+		groups[j].Bytes += c.Bytes
+	}
+
+	percent := func(n, of int64) float64 {
+		if of == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(of)
+	}
+	fmt.Fprintf(out, "%d of %d requests (%.1f%%) and %d of %d bytes (%.1f%%) came from %d AWS IPs out of %d\n",
+		fromAWS.Requests, total.Requests, percent(int64(fromAWS.Requests), int64(total.Requests)),
+		fromAWS.Bytes, total.Bytes, percent(fromAWS.Bytes, total.Bytes),
+		len(awsClients), len(clients))
+	if len(groups) == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(groups, func(a, b *group) int { return cmp.Compare(b.Requests, a.Requests) })
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nREGION\tSERVICE\tIPS\tREQUESTS\tBYTES")
+	for _, g := range groups {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", g.Region, g.Service, g.IPs, g.Requests, g.Bytes)
+	}
+	if *perIP {
+		slices.SortStableFunc(awsClients, func(a, b awsClient) int { return cmp.Compare(b.Requests, a.Requests) })
+		fmt.Fprintln(w, "\nIP\tREGION\tSERVICE\tREQUESTS\tBYTES")
+		for _, c := range awsClients {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", c.IP, c.Region, c.Service, c.Requests, c.Bytes)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieAlertsURL  = "https://api.opsgenie.com/v2/alerts"
+)
+
+// notifyClient is used for all the outgoing webhooks and API calls.
+var notifyClient = &http.Client{Timeout: 15 * time.Second}
+
+// alerter opens and closes incidents in an on-call tool. The dedup key is
+// stable for a given target so that repeated triggers don't open duplicate
+// incidents and so that the incident can be resolved automatically.
+type alerter interface {
+	Trigger(ctx context.Context, dedupKey, summary string, details map[string]string) error
+	Resolve(ctx context.Context, dedupKey string) error
+}
+
+// alertManager opens an incident when a watched target starts violating the
+// alerting criteria and resolves it once the target is back to normal:
+//
+//   - with allowed regions, a target violates the criteria when it leaves
+//     AWS or when it lands in a region that isn't in the list;
+//   - without allowed regions, a target violates the criteria when its
+//     classification differs from the one seen when the watch started.
+//
+// Resolution errors are ignored since a transient DNS failure is not a
+// reason to open or close an incident.
+type alertManager struct {
+	alerters       []alerter
+	allowedRegions []string
+
+	baseline map[string]Classification
+	open     map[string]bool
+}
+
+func newAlertManager(alerters []alerter, allowedRegions []string) *alertManager {
+	return &alertManager{
+		alerters:       alerters,
+		allowedRegions: allowedRegions,
+		baseline:       make(map[string]Classification),
+		open:           make(map[string]bool),
+	}
+}
+
+func (m *alertManager) HandleChanges(ctx context.Context, changes []Change) error {
+	var errs []error
+	for _, change := range changes {
+		if change.After.Error != "" {
+			continue
+		}
+		if _, ok := m.baseline[change.Target]; !ok {
+			m.baseline[change.Target] = change.After
+		}
+
+		key := "awswhois/" + change.Target
+		reason := m.violation(change)
+		switch {
+		case reason != "" && !m.open[key]:
+			details := map[string]string{"target": change.Target, "classification": change.After.Summary}
+			if change.Before != nil {
+				details["previous"] = change.Before.Summary
+			}
+			for _, a := range m.alerters {
+				if err := a.Trigger(ctx, key, fmt.Sprintf("%s %s", change.Target, reason), details); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			m.open[key] = true
+			slog.Info("alert triggered", "target", change.Target, "reason", reason)
+		case reason == "" && m.open[key]:
+			for _, a := range m.alerters {
+				if err := a.Resolve(ctx, key); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			delete(m.open, key)
+			slog.Info("alert resolved", "target", change.Target)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d alerting calls failed, first error: %w", len(errs), errs[0])
+	}
+	return nil
+}
+
+// violation returns why the target violates the alerting criteria, or an
+// empty string when it doesn't.
+func (m *alertManager) violation(change Change) string {
+	if len(m.allowedRegions) > 0 {
+		if !change.After.InAWS() {
+			if change.Before != nil && change.Before.InAWS() {
+				return "is no longer in AWS"
+			}
+			return "is not in AWS"
+		}
+		for _, region := range change.After.Regions {
+			if !slices.Contains(m.allowedRegions, region) {
+				return fmt.Sprintf("is now in %s, which is not an allowed region", region)
+			}
+		}
+		return ""
+	}
+
+	baseline := m.baseline[change.Target]
+	if change.After.Summary != baseline.Summary {
+		return fmt.Sprintf("moved from %s to %s", baseline.Summary, change.After.Summary)
+	}
+	return ""
+}
+
+// pagerDuty sends events to the PagerDuty Events API v2.
+type pagerDuty struct {
+	routingKey string
+}
+
+func (p *pagerDuty) Trigger(ctx context.Context, dedupKey, summary string, details map[string]string) error {
+	return postJSON(ctx, pagerDutyEventsURL, nil, map[string]any{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    dedupKey,
+		"payload": map[string]any{
+			"summary":        summary,
+			"source":         "awswhois",
+			"severity":       "warning",
+			"custom_details": details,
+		},
+	})
+}
+
+func (p *pagerDuty) Resolve(ctx context.Context, dedupKey string) error {
+	return postJSON(ctx, pagerDutyEventsURL, nil, map[string]any{
+		"routing_key":  p.routingKey,
+		"event_action": "resolve",
+		"dedup_key":    dedupKey,
+	})
+}
+
+// opsgenie creates and closes alerts with the Opsgenie Alert API. The dedup
+// key is used as the alert alias, which Opsgenie uses for deduplication.
+type opsgenie struct {
+	apiKey string
+}
+
+func (o *opsgenie) Trigger(ctx context.Context, dedupKey, summary string, details map[string]string) error {
+	// Opsgenie rejects messages longer than 130 characters.
+	return postJSON(ctx, opsgenieAlertsURL, o.headers(), map[string]any{
+		"message":     truncate(summary, 130),
+		"alias":       dedupKey,
+		"description": summary,
+		"details":     details,
+		"source":      "awswhois",
+	})
+}
+
+func (o *opsgenie) Resolve(ctx context.Context, dedupKey string) error {
+	closeURL := fmt.Sprintf("%s/%s/close?identifierType=alias", opsgenieAlertsURL, url.PathEscape(dedupKey))
+	return postJSON(ctx, closeURL, o.headers(), map[string]any{"source": "awswhois"})
+}
+
+func (o *opsgenie) headers() http.Header {
+	return http.Header{"Authorization": []string{"GenieKey " + o.apiKey}}
+}
+
+// postJSON sends the payload and fails on any non-2xx response.
+func postJSON(ctx context.Context, endpoint string, headers http.Header, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("POST %s: HTTP %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
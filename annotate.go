@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// annotateFields are the fields that "awswhois annotate" appends to each
+// record.
+var annotateFields = []string{"aws_match", "aws_region", "aws_service"}
+
+// enrichment is what annotate appends to a record, from the most specific
+// match of its target.
+type enrichment struct {
+	Match   bool
+	Region  string
+	Service string
+}
+
+func (a enrichment) values() []string {
+	return []string{strconv.FormatBool(a.Match), a.Region, a.Service}
+}
+
+// runAnnotate implements "awswhois annotate", a filter that reads CSV, TSV,
+// or JSON Lines records on stdin and writes them back with the AWS region and
+// service of one of their fields appended, so that it can sit in the middle
+// of a pipeline.
+func runAnnotate(args []string, source rangesSource, opts lookupOptions, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("annotate", flag.ContinueOnError)
+	field := fs.String("field", "", "the CSV column or JSON key that holds the IP, hostname, or URL to look up")
+	format := fs.String("format", "", "the format of the records: csv, tsv, or jsonl (default: jsonl when the input starts with '{', csv otherwise)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] annotate --field <name> [--format csv|tsv|jsonl] < records\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *field == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return usagef("annotate needs --field and reads the records from stdin")
+	}
+
+	br := bufio.NewReader(in)
+	if *format == "" {
+		*format = "csv"
+		if first, err := br.Peek(1); err == nil && first[0] == '{' {
+			*format = "jsonl"
+		}
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	// A value repeated in the records, as IPs are in logs, is only looked
+	// up once.
+	cache := make(map[string]enrichment)
+	annotate := func(value string) enrichment {
+		if value == "" {
+			return enrichment{}
+		}
+		if a, ok := cache[value]; ok {
+			return a
+		}
+		var a enrichment
+		result, err := lookupInput(context.Background(), value, ranges, opts, io.Discard)
+		if err != nil {
+			slog.Warn("can't annotate record", "err", err)
+		} else {
+			a.Region, a.Match = printValue(result, "region")
+			a.Service, _ = printValue(result, "service")
+		}
+		cache[value] = a
+		return a
+	}
+
+	switch *format {
+	case "csv":
+		return annotateDelimited(br, out, ',', *field, annotate)
+	case "tsv":
+		return annotateDelimited(br, out, '\t', *field, annotate)
+	case "jsonl":
+		return annotateJSONLines(br, out, *field, annotate)
+	}
+	return usagef("unknown format %q, must be one of: csv, tsv, jsonl", *format)
+}
+
+// annotateDelimited annotates CSV or TSV records, whose first row is the
+// header. Each row is written as soon as it is annotated.
+func annotateDelimited(in io.Reader, out io.Writer, comma rune, field string, annotate func(string) enrichment) error {
+	r := csv.NewReader(in)
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	w := csv.NewWriter(out)
+	w.Comma = comma
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	col := -1
+	for i, name := range header {
+		if name == field {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		return fmt.Errorf("no column %q in the header", field)
+	}
+	w.Write(append(header, annotateFields...))
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var a enrichment
+		if col < len(record) {
+			a = annotate(record[col])
+		}
+		w.Write(append(record, a.values()...))
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// annotateJSONLines annotates a JSON object per line. The fields are appended
+// to the line as it is, so that the other fields keep their order and
+// formatting; the lines that aren't objects are written unchanged.
+func annotateJSONLines(in io.Reader, out io.Writer, field string, annotate func(string) enrichment) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for num := 1; scanner.Scan(); num++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		var record map[string]any
+		if err := json.Unmarshal(line, &record); err != nil || record == nil {
+			if len(line) > 0 {
+				slog.Warn("can't annotate record, not a JSON object", "line", num)
+			}
+			fmt.Fprintf(out, "%s\n", scanner.Bytes())
+			continue
+		}
+		value, _ := record[field].(string)
+		a := annotate(value)
+		region, _ := json.Marshal(a.Region)
+		service, _ := json.Marshal(a.Service)
+		sep := ","
+		if len(record) == 0 {
+			sep = "" // {}
+		}
+		_, err := fmt.Fprintf(out, "%s%s\"aws_match\":%t,\"aws_region\":%s,\"aws_service\":%s}\n",
+			line[:len(line)-1], sep, a.Match, region, service)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
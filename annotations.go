@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// annotation attaches free-form tags, such as the owning team or a ticket, to
+// the prefixes it selects. A prefix selects the AWS prefixes it contains;
+// region and service select by location and service. When several are given,
+// all of them must match.
+type annotation struct {
+	Prefix  string            `yaml:"prefix"`
+	Region  string            `yaml:"region"`
+	Service string            `yaml:"service"`
+	Tags    map[string]string `yaml:"tags"`
+
+	prefix netip.Prefix
+}
+
+// annotations are read from the file given with --annotations, a YAML list of
+// entries with a prefix, a region and/or a service, and a map of tags.
+type annotations []annotation
+
+func readAnnotations(path string) (annotations, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a annotations
+	if err := yaml.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i := range a {
+		if a[i].Prefix == "" && a[i].Region == "" && a[i].Service == "" {
+			return nil, fmt.Errorf("%s: entry %d: needs a prefix, a region, or a service", path, i+1)
+		}
+		if a[i].Prefix != "" {
+			a[i].prefix, err = parseCustomPrefix(a[i].Prefix)
+			if err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %w", path, i+1, err)
+			}
+		}
+		a[i].Service = strings.ToUpper(a[i].Service)
+	}
+	return a, nil
+}
+
+func (a annotation) selects(match AWSMatch) bool {
+	if a.Region != "" && a.Region != match.Region {
+		return false
+	}
+	if a.Service != "" && a.Service != strings.ToUpper(match.Service) {
+		return false
+	}
+	if a.prefix.IsValid() {
+		p, err := netip.ParsePrefix(match.Prefix)
+		if err != nil || p.Bits() < a.prefix.Bits() || !a.prefix.Contains(p.Addr()) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagsFor returns the tags of the match. When several annotations give the
+// same tag, the last one in the file wins.
+func (a annotations) tagsFor(match AWSMatch) map[string]string {
+	tags := make(map[string]string)
+	for _, ann := range a {
+		if ann.selects(match) {
+			maps.Copy(tags, ann.Tags)
+		}
+	}
+	return tags
+}
+
+// tagsForGroup merges the tags of each of the services of the group.
+func (a annotations) tagsForGroup(group GroupedMatch) map[string]string {
+	tags := make(map[string]string)
+	for _, service := range strings.Split(group.Services, ",") {
+		maps.Copy(tags, a.tagsFor(AWSMatch{
+			Prefix:             group.Prefix,
+			Region:             group.Region,
+			Service:            service,
+			NetworkBorderGroup: group.NetworkBorderGroup,
+			Feed:               group.Feed,
+		}))
+	}
+	return tags
+}
+
+// formatTags shows the tags as "key=value" pairs sorted by key, or "-".
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return "-"
+	}
+	var pairs []string
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// tagFilter is a --tag value: "key=value" keeps the matches with that tag,
+// "key" keeps the matches that have the tag at all.
+type tagFilter struct {
+	Key, Value string
+	AnyValue   bool
+}
+
+func parseTagFilter(s string) tagFilter {
+	key, value, ok := strings.Cut(s, "=")
+	return tagFilter{Key: key, Value: value, AnyValue: !ok}
+}
+
+func (f tagFilter) keeps(tags map[string]string) bool {
+	value, ok := tags[f.Key]
+	return ok && (f.AnyValue || value == f.Value)
+}
+
+func filterByTags(matches []AWSMatch, a annotations, filters []tagFilter) []AWSMatch {
+	var filtered []AWSMatch
+	for _, match := range matches {
+		tags := a.tagsFor(match)
+		keep := true
+		for _, f := range filters {
+			keep = keep && f.keeps(tags)
+		}
+		if keep {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// rule is a condition on a match used by assert, e.g. "service in
+// [CLOUDFRONT, S3]" or "region == us-east-1".
+type rule struct {
+	Text   string
+	Field  string
+	Negate bool
+	Values []string
+}
+
+var ruleFields = []string{"service", "region", "zone-type", "border-group", "prefix"}
+
+var ruleRegexp = regexp.MustCompile(`^\s*([a-z_-]+)\s*(==|!=|not\s+in\b|in\b)\s*(.*?)\s*$`)
+
+func parseRule(s string) (rule, error) {
+	m := ruleRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return rule{}, fmt.Errorf("%q must be of the form 'field == value', 'field != value', 'field in [a, b]', or 'field not in [a, b]'", s)
+	}
+	r := rule{Text: strings.TrimSpace(s), Field: strings.ReplaceAll(m[1], "_", "-")}
+	if !slices.Contains(ruleFields, r.Field) {
+		return rule{}, fmt.Errorf("%q: unknown field %q, must be one of: %s", s, m[1], strings.Join(ruleFields, ", "))
+	}
+
+	op, value := strings.Join(strings.Fields(m[2]), " "), m[3]
+	r.Negate = op == "!=" || op == "not in"
+	if op == "in" || op == "not in" {
+		if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+			return rule{}, fmt.Errorf("%q: expected a list such as [a, b] after %q", s, op)
+		}
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.Trim(strings.TrimSpace(v), `"'`); v != "" {
+				r.Values = append(r.Values, v)
+			}
+		}
+	} else if v := strings.Trim(value, `"'`); v != "" {
+		r.Values = []string{v}
+	}
+	if len(r.Values) == 0 {
+		return rule{}, fmt.Errorf("%q: missing value", s)
+	}
+	return r, nil
+}
+
+// matches tells whether the match satisfies the rule. Values are compared
+// case-insensitively.
+func (r rule) matches(m AWSMatch) bool {
+	got := fieldValue(m, r.Field)
+	found := slices.ContainsFunc(r.Values, func(v string) bool { return strings.EqualFold(v, got) })
+	return found != r.Negate
+}
+
+func fieldValue(m AWSMatch, field string) string {
+	switch field {
+	case "service":
+		return m.Service
+	case "region":
+		return m.Region
+	case "zone-type":
+		return string(matchZoneType(m))
+	case "border-group":
+		return m.NetworkBorderGroup
+	case "prefix":
+		return m.Prefix
+	}
+	return ""
+}
+
+// violation is a rule broken by one of the IPs of a target.
+type violation struct {
+	Target, Subject, Rule, Found string
+}
+
+// checkRules returns the rules broken by the result. Each IP must have at
+// least one match satisfying every required rule, and no match satisfying a
+// forbidden one.
+func checkRules(result Result, require, forbid []rule) []violation {
+	var violations []violation
+	for _, subject := range result.Subjects {
+		found := "not AWS"
+		if len(subject.Matches) > 0 {
+			var parts []string
+			for _, group := range groupMatches(subject.Matches) {
+				parts = append(parts, fmt.Sprintf("%s %s (%s)", group.Prefix, group.Region, group.Services))
+			}
+			found = strings.Join(parts, ", ")
+		}
+
+		for _, r := range require {
+			if !slices.ContainsFunc(subject.Matches, r.matches) {
+				violations = append(violations, violation{result.Target.Input, subject.Label, "require " + r.Text, found})
+			}
+		}
+		for _, r := range forbid {
+			var forbidden []AWSMatch
+			for _, m := range subject.Matches {
+				if r.matches(m) {
+					forbidden = append(forbidden, m)
+				}
+			}
+			for _, group := range groupMatches(forbidden) {
+				violations = append(violations, violation{result.Target.Input, subject.Label, "forbid " + r.Text,
+					fmt.Sprintf("%s %s (%s)", group.Prefix, group.Region, group.Services)})
+			}
+		}
+	}
+	return violations
+}
+
+// errViolations is returned by runAssert when a rule is broken.
+var errViolations = errors.New("rules violated")
+
+// runAssert implements "awswhois assert", meant for CI gates on deployment
+// configs: the targets are looked up and checked against the rules, and the
+// broken rules are reported.
+func runAssert(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("assert", flag.ContinueOnError)
+	var require, forbid []rule
+	addRule := func(rules *[]rule) func(string) error {
+		return func(s string) error {
+			r, err := parseRule(s)
+			if err != nil {
+				return err
+			}
+			*rules = append(*rules, r)
+			return nil
+		}
+	}
+	fs.Func("require", "rule that every IP of every target must satisfy with at least one of its matches, e.g. 'service in [CLOUDFRONT]'; can be repeated", addRule(&require))
+	fs.Func("forbid", "rule that no match may satisfy, e.g. 'region == us-east-1'; can be repeated", addRule(&forbid))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] assert [--require <rule>]... [--forbid <rule>]... <target>... | -\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "A rule is 'field == value', 'field != value', 'field in [a, b]', or 'field not in [a, b]'\nwhere field is one of: %s.\n\n", strings.Join(ruleFields, ", "))
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if len(require) == 0 && len(forbid) == 0 {
+		fs.Usage()
+		return usagef("at least one --require or --forbid rule is needed")
+	}
+
+	var inputs []TargetLine
+	switch {
+	case fs.NArg() == 1 && fs.Arg(0) == "-":
+		lines, err := readTargets(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("reading targets from stdin: %w", err)
+		}
+		inputs = lines
+	case fs.NArg() > 0:
+		for _, arg := range fs.Args() {
+			inputs = append(inputs, TargetLine{Text: arg})
+		}
+	default:
+		fs.Usage()
+		return usagef("no targets given")
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	opts, err = opts.resolveNames(ranges)
+	if err != nil {
+		return err
+	}
+
+	// A target that can't be looked up can't be vouched for, so it counts as
+	// a violation.
+	var violations []violation
+	for _, input := range inputs {
+		result, err := lookupInput(context.Background(), input.Text, ranges, opts, io.Discard)
+		if err != nil {
+			violations = append(violations, violation{input.Text, "-", "lookup", "error: " + err.Error()})
+			continue
+		}
+		violations = append(violations, checkRules(result, require, forbid)...)
+	}
+
+	if len(violations) == 0 {
+		fmt.Fprintf(out, "OK: %d targets satisfy %d rules\n", len(inputs), len(require)+len(forbid))
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tIP\tRULE\tFOUND")
+	for _, v := range violations {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Target, v.Subject, v.Rule, v.Found)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n%d violations\n", len(violations))
+	return errViolations
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// lookupOutcome is what lookupInput returned for one target of a batch.
+type lookupOutcome struct {
+	Result Result
+	Err    error
+}
+
+// lookupAll looks the targets up with at most concurrency lookups at a time,
+// since a batch of hostnames mostly waits on DNS. A target repeated in the
+// batch, e.g. an IP found on thousands of lines of a log, is only looked up
+// once. The returned function waits for the outcome of the i-th target, so
+// that the caller can handle them in the order of the targets while the next
+// ones are still being looked up.
+func lookupAll(ctx context.Context, inputs []TargetLine, concurrency int, ranges *AWSIPRanges, opts lookupOptions, errOut io.Writer) func(i int) lookupOutcome {
+	// first maps each target to the index of its first occurrence, which is
+	// the one looked up.
+	first := make([]int, len(inputs))
+	seen := make(map[string]int)
+	var unique []int
+	for i, input := range inputs {
+		j, ok := seen[input.Text]
+		if !ok {
+			j = i
+			seen[input.Text] = i
+			unique = append(unique, i)
+		}
+		first[i] = j
+	}
+
+	outcomes := make([]lookupOutcome, len(inputs))
+	done := make([]chan struct{}, len(inputs))
+	for _, i := range unique {
+		done[i] = make(chan struct{})
+	}
+
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for _, i := range unique {
+			next <- i
+		}
+	}()
+	for range min(concurrency, len(unique)) {
+		go func() {
+			for i := range next {
+				result, err := lookupInput(ctx, inputs[i].Text, ranges, opts, errOut)
+				outcomes[i] = lookupOutcome{Result: result, Err: err}
+				close(done[i])
+			}
+		}()
+	}
+	return func(i int) lookupOutcome {
+		<-done[first[i]]
+		return outcomes[first[i]]
+	}
+}
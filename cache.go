@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const cacheFileName = "ip-ranges.json"
+
+// cacheValidators are the ETag and Last-Modified headers of the download of
+// the cached copy, kept next to it so that refreshing it is a conditional
+// request, which costs nothing when ip-ranges.json hasn't changed. URL is
+// where the copy was downloaded from: the validators of one URL mean nothing
+// to another.
+type cacheValidators struct {
+	URL          string `json:"url,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// rangesSource tells where the AWS IP ranges are loaded from.
+type rangesSource struct {
+	// CacheDir is where a copy of ip-ranges.json is kept, by default in the
+	// user's cache directory, or e.g. on a volume shared by a fleet of hosts.
+	// Every download updates it.
+	CacheDir string
+
+	// CacheTTL is how long the cached copy is used before it is downloaded
+	// again. With 0, it is downloaded every time.
+	CacheTTL time.Duration
+
+	// CacheReadOnly makes the cached copy the only source: the network is
+	// never used and nothing is written, so that thousands of readers can
+	// share a volume refreshed by a single "fetch" job.
+	CacheReadOnly bool
+
+	// Offline loads the snapshot embedded in the binary, for the hosts that
+	// can't reach ip-ranges.amazonaws.com at all.
+	Offline bool
+
+	// RangesFile is a copy of ip-ranges.json to load instead of the current
+	// one, so that lookups can be reproduced against a known version. With
+	// "-", the copy is RangesBody, read from stdin, which can only be read
+	// once.
+	RangesFile string
+	RangesBody []byte
+
+	// URL is where ip-ranges.json is downloaded from, awsIPRangesURL unless
+	// an internal mirror is used.
+	URL string
+
+	// Timeout bounds each attempt to download the ranges, and a failed
+	// download is attempted Retries more times.
+	Timeout time.Duration
+	Retries int
+
+	// Feeds are loaded along with the ranges so that they are refreshed
+	// together.
+	Feeds []feed
+
+	// ExcludePrefixes are removed from the ranges and the feeds as soon as
+	// they are loaded, so that no lookup or listing ever sees them.
+	ExcludePrefixes []netip.Prefix
+
+	// Ranges, when set, are ranges already loaded from this source, which
+	// load returns as is, e.g. for the export of a watch to reuse the ranges
+	// that it just refreshed.
+	Ranges *AWSIPRanges
+}
+
+func (s rangesSource) cachePath() string {
+	return filepath.Join(s.CacheDir, cacheFileName)
+}
+
+func (s rangesSource) validatorsPath() string {
+	return s.cachePath() + ".validators"
+}
+
+// defaultCacheDir returns the awswhois directory of the user's cache
+// directory, e.g. $XDG_CACHE_HOME/awswhois on Linux, or an empty string when
+// there is none.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		slog.Debug("no user cache directory", "err", err)
+		return ""
+	}
+	return filepath.Join(dir, "awswhois")
+}
+
+// rangesError is returned by load when the ranges can't be loaded, which
+// exits with exitRangesFailure whatever the subcommand.
+type rangesError struct{ err error }
+
+func (e rangesError) Error() string { return e.err.Error() }
+func (e rangesError) Unwrap() error { return e.err }
+
+// load returns the AWS IP ranges, from the cache when it is read-only or
+// younger than the TTL and from the network otherwise, along with the
+// prefixes of the feeds, minus the excluded prefixes.
+func (s rangesSource) load() (*AWSIPRanges, error) {
+	if s.Ranges != nil {
+		return s.Ranges, nil
+	}
+	ranges, err := s.loadAWS()
+	if err != nil {
+		return nil, rangesError{err}
+	}
+	ctx, stop := interruptible()
+	defer stop()
+	ranges.Custom, err = s.loadFeeds(ctx)
+	if err != nil {
+		return nil, rangesError{err}
+	}
+	ranges.excludePrefixes(s.ExcludePrefixes)
+	return ranges, nil
+}
+
+func (s rangesSource) loadAWS() (*AWSIPRanges, error) {
+	if s.Offline {
+		return loadSnapshot()
+	}
+	if s.RangesFile == "-" {
+		return parseRanges("stdin", s.RangesBody)
+	}
+	if s.RangesFile != "" {
+		slog.Debug("loading AWS IP ranges", "file", s.RangesFile)
+		return readRangesFile(s.RangesFile)
+	}
+	if s.CacheReadOnly {
+		return s.readCache()
+	}
+	if s.CacheDir == "" {
+		slog.Debug("no --cache-dir, the AWS IP ranges are downloaded every time")
+		ctx, stop := interruptible()
+		defer stop()
+		ranges, _, _, err := s.download(ctx, cacheValidators{})
+		return ranges, err
+	}
+	if s.CacheTTL > 0 {
+		info, err := os.Stat(s.cachePath())
+		_, sameURL := s.readValidators()
+		switch {
+		case err != nil:
+			slog.Debug("cache miss", "file", s.cachePath())
+		case !sameURL:
+			slog.Debug("cached copy was downloaded from another URL", "file", s.cachePath(), "url", s.URL)
+		case time.Since(info.ModTime()) >= s.CacheTTL:
+			slog.Debug("cached copy is stale", "file", s.cachePath(), "age", time.Since(info.ModTime()).Round(time.Second), "ttl", s.CacheTTL)
+		default:
+			ranges, err := s.readCache()
+			if err == nil {
+				return ranges, nil
+			}
+			slog.Warn("reading the cache", "dir", s.CacheDir, "err", err)
+		}
+	}
+
+	// The lookup doesn't need the cache, so failing to update it is only
+	// worth a warning.
+	return s.refresh(func(err error) { slog.Warn("updating the cache", "dir", s.CacheDir, "err", err) })
+}
+
+// fetch downloads the ranges and stores them in the cache. It is what the
+// "fetch" role runs.
+func (s rangesSource) fetch() (*AWSIPRanges, error) {
+	var cacheErr error
+	ranges, err := s.refresh(func(err error) { cacheErr = err })
+	if err != nil {
+		return nil, err
+	}
+	return ranges, cacheErr
+}
+
+// refresh downloads the ranges into the cache, with a conditional request
+// when there is a cached copy. When they haven't changed, the cached copy is
+// touched so that it is fresh for another TTL and returned. Failures to
+// update the cache are passed to cacheErr.
+func (s rangesSource) refresh(cacheErr func(error)) (*AWSIPRanges, error) {
+	var since cacheValidators
+	if _, err := os.Stat(s.cachePath()); err == nil {
+		if validators, sameURL := s.readValidators(); sameURL {
+			since = validators
+		}
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	ranges, body, validators, err := s.download(ctx, since)
+	if errors.Is(err, errNotModified) {
+		slog.Debug("AWS IP ranges not modified, using the cached copy", "file", s.cachePath())
+		now := time.Now()
+		if err := os.Chtimes(s.cachePath(), now, now); err != nil {
+			cacheErr(err)
+		}
+		return s.readCache()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeCache(body, validators); err != nil {
+		cacheErr(err)
+	} else {
+		slog.Debug("updated the cache", "file", s.cachePath())
+	}
+	return ranges, nil
+}
+
+// readValidators returns the validators of the cached copy and whether it
+// was downloaded from s.URL. A copy from another URL, or from a version that
+// didn't record it, is a cache miss.
+func (s rangesSource) readValidators() (validators cacheValidators, sameURL bool) {
+	b, err := os.ReadFile(s.validatorsPath())
+	if err != nil {
+		return cacheValidators{}, false
+	}
+	if err := json.Unmarshal(b, &validators); err != nil {
+		return cacheValidators{}, false
+	}
+	return validators, validators.URL == s.URL
+}
+
+// retryBaseDelay is the delay before the first retry of a download, which
+// doubles with each retry.
+const retryBaseDelay = time.Second
+
+// interruptible returns a context that SIGINT and SIGTERM cancel. Until stop
+// is called, these signals cancel the download instead of killing the
+// process, so that run returns and its deferred shutdowns flush the
+// telemetry.
+func interruptible() (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// download fetches the ranges, retrying the failures that may be temporary.
+func (s rangesSource) download(ctx context.Context, since cacheValidators) (ranges *AWSIPRanges, body []byte, validators cacheValidators, err error) {
+	err = s.withRetries(ctx, "downloading AWS IP ranges", func(ctx context.Context) error {
+		ranges, body, validators, err = fetchAWSIPRanges(ctx, s.URL, since)
+		return err
+	})
+	if err != nil {
+		return nil, nil, cacheValidators{}, err
+	}
+	return ranges, body, validators, nil
+}
+
+// withRetries runs attempt, bounded by s.Timeout, and retries the failures
+// that may be temporary: the network errors, the timeouts, and the 429 and
+// 5xx statuses, at most s.Retries times. The delays between the attempts
+// are jittered so that a fleet of hosts whose downloads failed together
+// don't retry together. Cancelling ctx stops both the attempts and the waits
+// between them.
+func (s rangesSource) withRetries(ctx context.Context, what string, attempt func(context.Context) error) error {
+	for n := 0; ; n++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, s.Timeout)
+		err := attempt(attemptCtx)
+		cancel()
+		if err == nil || n >= s.Retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		delay := retryBaseDelay << n
+		delay = delay/2 + rand.N(delay/2)
+		slog.Warn(what+" failed, retrying", "err", err, "attempt", n+1, "in", delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, giving up: %w", err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryable tells whether a failed download may succeed if attempted again.
+func retryable(err error) bool {
+	var statusErr *httpStatusError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, errNotModified):
+		return false
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return false
+	}
+	return true
+}
+
+func (s rangesSource) readCache() (*AWSIPRanges, error) {
+	body, err := os.ReadFile(s.cachePath())
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Debug("cache miss", "file", s.cachePath())
+		return nil, fmt.Errorf("no cached AWS IP ranges in %s, run '%s --cache-dir %s fetch' first", s.CacheDir, os.Args[0], s.CacheDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := AWSIPRanges{FromCache: true}
+	if err := json.Unmarshal(body, &ranges); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.cachePath(), err)
+	}
+	// The cached copy is written right after each download.
+	if info, err := os.Stat(s.cachePath()); err == nil {
+		ranges.FetchedAt = info.ModTime().UTC()
+	}
+	slog.Debug("loaded cached AWS IP ranges", "file", s.cachePath(),
+		"syncToken", ranges.SyncToken, "createDate", ranges.CreateDate)
+	return &ranges, nil
+}
+
+// writeCache replaces the cached copy atomically so that readers never see a
+// half-written file, along with its validators. Stale validators would only
+// cost a full download, so they are written after the copy.
+func (s rangesSource) writeCache(body []byte, validators cacheValidators) error {
+	if err := os.MkdirAll(s.CacheDir, 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.cachePath(), body); err != nil {
+		return err
+	}
+	validators.URL = s.URL
+	b, err := json.Marshal(validators)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.validatorsPath(), b)
+}
+
+// writeFileAtomic replaces the file at path so that readers see either the
+// old content or the new one, never a partial write.
+func writeFileAtomic(path string, body []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp makes the file readable by its owner only, which would
+	// lock out readers running as other users.
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheKeyedByURL(t *testing.T) {
+	serve := func(syncToken string) *httptest.Server {
+		// Like a lazy mirror, it answers 304 to any conditional request.
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") != "" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"`+syncToken+`"`)
+			w.Write([]byte(`{"syncToken":"` + syncToken + `","createDate":"2024-05-02-00-00-00","prefixes":[]}`))
+		}))
+	}
+	aws, mirror := serve("aws"), serve("mirror")
+	defer aws.Close()
+	defer mirror.Close()
+
+	dir := t.TempDir()
+	load := func(url string, ttl time.Duration) string {
+		t.Helper()
+		ranges, err := rangesSource{CacheDir: dir, CacheTTL: ttl, URL: url, Timeout: 5 * time.Second}.load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ranges.SyncToken
+	}
+
+	if got := load(aws.URL, time.Hour); got != "aws" {
+		t.Fatalf("got %q from the first download, want aws", got)
+	}
+	// The copy is fresh, but from another URL.
+	if got := load(mirror.URL, time.Hour); got != "mirror" {
+		t.Errorf("got %q from the mirror within the TTL, want mirror", got)
+	}
+	// The mirror's copy is cached, and the ETag it was downloaded with
+	// mustn't be sent to AWS.
+	if got := load(aws.URL, 0); got != "aws" {
+		t.Errorf("got %q from a conditional request to AWS, want aws", got)
+	}
+	if got := load(aws.URL, 0); got != "aws" {
+		t.Errorf("got %q when AWS answers 304, want aws", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// checkpointRecord is one line of the checkpoint file: the outcome of one
+// target of a batch.
+type checkpointRecord struct {
+	Line     int                 `json:"line,omitempty"`
+	Input    string              `json:"input"`
+	Subjects []checkpointSubject `json:"subjects,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+type checkpointSubject struct {
+	Label   string            `json:"label"`
+	Matches []checkpointMatch `json:"matches,omitempty"`
+}
+
+type checkpointMatch struct {
+	Prefix             string `json:"prefix"`
+	Region             string `json:"region"`
+	Services           string `json:"services"`
+	NetworkBorderGroup string `json:"network_border_group"`
+}
+
+// checkpoint appends the outcome of each target to a file as soon as it is
+// known, one JSON object per line, so that a batch that crashes or gets
+// killed halfway doesn't lose the lookups already done. Each line is synced
+// to disk before the next target is looked up.
+type checkpoint struct {
+	f *os.File
+}
+
+// openCheckpoint opens the checkpoint file for appending. Unless resume is
+// set, the records of a previous run are discarded.
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start on a new line if the previous run died in the middle of one.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := f.Write([]byte("\n")); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return &checkpoint{f: f}, nil
+}
+
+func (c *checkpoint) Record(line int, result Result) error {
+	record := checkpointRecord{Line: line, Input: result.Target.Input}
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+	}
+	for _, subject := range result.Subjects {
+		s := checkpointSubject{Label: subject.Label}
+		for _, group := range groupMatches(subject.Matches) {
+			s.Matches = append(s.Matches, checkpointMatch{
+				Prefix:             group.Prefix,
+				Region:             group.Region,
+				Services:           group.Services,
+				NetworkBorderGroup: group.NetworkBorderGroup,
+			})
+		}
+		record.Subjects = append(record.Subjects, s)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := c.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return c.f.Sync()
+}
+
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
+
+// readCheckpoint returns the inputs already recorded in the checkpoint file.
+// A missing file means nothing was done yet. Targets that failed are left out
+// so that they are tried again, e.g. after a DNS outage. The last line may
+// have been cut short by a crash; lines that can't be decoded are skipped so
+// that their target is looked up again.
+func readCheckpoint(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var record checkpointRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Error != "" {
+			continue
+		}
+		done[record.Input] = true
+	}
+	return done, scanner.Err()
+}
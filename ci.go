@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// policy is what the github, junit, and nagios outputs check each IP
+// against: the --expect rules and the --fail-if-aws or --fail-if-not-aws
+// gate. Without any of them, an IP outside AWS is only worth a warning.
+type policy struct {
+	Expect       []rule
+	FailIfAWS    bool
+	FailIfNotAWS bool
+}
+
+// severity levels of a finding, named after the GitHub workflow commands.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// finding is an IP, or a target that couldn't be looked up, that breaks the
+// policy.
+type finding struct {
+	Severity string
+	Subject  string
+	Message  string
+}
+
+// check returns the findings of a result, none when all its IPs pass.
+func (p policy) check(result Result) []finding {
+	if result.Err != nil {
+		return []finding{{Severity: severityError, Subject: result.Target.Input, Message: "couldn't be looked up: " + result.Err.Error()}}
+	}
+	var findings []finding
+	for _, subject := range result.Subjects {
+		inAWS := len(subject.Matches) > 0
+		notInAWS := "is not in AWS"
+		if class := specialClass(subject.Label); class != "" {
+			notInAWS += ": " + class
+		}
+		switch {
+		case p.FailIfAWS && inAWS:
+			findings = append(findings, finding{severityError, subject.Label, "is in AWS: " + groupMatches(subject.Matches)[0].Prefix})
+		case p.FailIfNotAWS && !inAWS:
+			findings = append(findings, finding{severityError, subject.Label, notInAWS})
+		case len(p.Expect) == 0 && !p.FailIfAWS && !p.FailIfNotAWS && !inAWS:
+			findings = append(findings, finding{severityWarning, subject.Label, notInAWS})
+		}
+		if len(p.Expect) == 0 {
+			continue
+		}
+		missing, _ := unmetExpectations(subject.Matches, p.Expect)
+		if len(missing) == 0 {
+			continue
+		}
+		var expected, got []string
+		for _, r := range missing {
+			expected = append(expected, r.Text)
+			got = append(got, r.Field+"="+strings.Join(fieldValues(subject.Matches, r.Field), ","))
+		}
+		message := fmt.Sprintf("expected %s, got %s", strings.Join(expected, ", "), strings.Join(got, ", "))
+		if !inAWS {
+			message = fmt.Sprintf("expected %s, not in AWS", strings.Join(expected, ", "))
+		}
+		findings = append(findings, finding{severityError, subject.Label, message})
+	}
+	return findings
+}
+
+// githubEscaper escapes the message of a GitHub workflow command, which ends
+// at the first newline.
+var githubEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+// writeGitHubAnnotations writes a "::error" or "::warning" workflow command
+// per finding of the result, which GitHub Actions shows as an annotation of
+// the run.
+func writeGitHubAnnotations(out io.Writer, result Result, p policy) error {
+	for _, f := range p.check(result) {
+		_, err := fmt.Fprintf(out, "::%s title=awswhois::%s\n", f.Severity, githubEscaper.Replace(f.Subject+" "+f.Message))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeGitHubSummary ends the github output with a line of the run's log
+// that counts the findings.
+func writeGitHubSummary(out io.Writer, results []Result, p policy) {
+	var errs, warnings int
+	for _, result := range results {
+		for _, f := range p.check(result) {
+			if f.Severity == severityError {
+				errs++
+			} else {
+				warnings++
+			}
+		}
+	}
+	fmt.Fprintf(out, "awswhois: %d targets checked, %d errors, %d warnings\n", len(results), errs, warnings)
+}
+
+// junitSuites is the root of the junit output, in the schema that Jenkins,
+// GitLab, and most CI systems read.
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes a test case per target: it fails when an IP breaks the
+// policy, or is outside AWS when there is no policy, and errors when the
+// target couldn't be looked up.
+func writeJUnit(out io.Writer, results []Result, p policy) error {
+	suite := junitSuite{Name: "awswhois", Tests: len(results)}
+	for _, result := range results {
+		c := junitCase{Name: result.Target.Input, ClassName: "awswhois"}
+		var lines []string
+		for _, f := range p.check(result) {
+			lines = append(lines, f.Subject+" "+f.Message)
+		}
+		switch {
+		case result.Err != nil:
+			c.Error = &junitProblem{Message: lines[0], Text: lines[0]}
+			suite.Errors++
+		case len(lines) > 0:
+			c.Failure = &junitProblem{Message: lines[0], Text: strings.Join(lines, "\n")}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
+
+// The exit codes of a Nagios or Icinga plugin.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// writeNagios writes the single status line of a Nagios plugin, with the
+// counts as performance data, and returns the exit code that goes with it:
+// CRITICAL when an IP breaks the policy, UNKNOWN when a target couldn't be
+// looked up, WARNING when an IP is outside AWS and there is no policy, and
+// OK otherwise.
+func writeNagios(out io.Writer, results []Result, p policy) int {
+	var ips, inAWS, lookupErrors int
+	var critical, warning []finding
+	for _, result := range results {
+		if result.Err != nil {
+			lookupErrors++
+			continue
+		}
+		for _, subject := range result.Subjects {
+			ips++
+			if len(subject.Matches) > 0 {
+				inAWS++
+			}
+		}
+		for _, f := range p.check(result) {
+			if f.Severity == severityError {
+				critical = append(critical, f)
+			} else {
+				warning = append(warning, f)
+			}
+		}
+	}
+
+	code, summary := nagiosOK, fmt.Sprintf("%d of %d IPs in AWS", inAWS, ips)
+	describe := func(findings []finding) string {
+		s := findings[0].Subject + " " + findings[0].Message
+		if len(findings) > 1 {
+			s += fmt.Sprintf(" (and %d more)", len(findings)-1)
+		}
+		return s
+	}
+	switch {
+	case len(critical) > 0:
+		code, summary = nagiosCritical, describe(critical)
+	case lookupErrors > 0:
+		code, summary = nagiosUnknown, fmt.Sprintf("%d of %d targets couldn't be looked up", lookupErrors, len(results))
+	case len(warning) > 0:
+		code, summary = nagiosWarning, describe(warning)
+	}
+	fmt.Fprintf(out, "AWSWHOIS %s - %s | ips=%d in_aws=%d;;;0;%d errors=%d\n", nagiosStates[code], summary, ips, inAWS, ips, lookupErrors)
+	return code
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// subcommands are offered when completing the first word that isn't a flag.
+var subcommands = []string{"lookup", "repl", "tui", "assert", "list", "ranges", "stats", "export", "annotate", "zone", "hosts", "known-hosts", "log", "pcap", "diff", "serve", "fetch", "validate", "watch", "completion"}
+
+// completionFlag is a flag of the main command as the completion scripts see
+// it.
+type completionFlag struct {
+	Name   string
+	Usage  string
+	IsBool bool
+}
+
+// completionData holds the values the scripts complete. The regions and
+// services aren't part of it: the scripts ask for them with "__complete" so
+// that they follow the ranges rather than the version of the binary.
+type completionData struct {
+	Flags       []completionFlag
+	Subcommands string
+	Exports     string
+	Outputs     string
+	ZoneTypes   string
+	SortKeys    string
+}
+
+func newCompletionData() completionData {
+	var flags []completionFlag
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{Name: f.Name, Usage: f.Usage, IsBool: ok && b.IsBoolFlag()})
+	})
+	var zones []string
+	for _, z := range zoneTypes {
+		zones = append(zones, string(z))
+	}
+	return completionData{
+		Flags:       flags,
+		Subcommands: strings.Join(subcommands, " "),
+		Exports:     strings.Join(slices.Sorted(maps.Keys(exporters)), " "),
+		Outputs:     strings.Join(outputFormats, " "),
+		ZoneTypes:   strings.Join(zones, " "),
+		SortKeys:    strings.Join(sortKeys, " "),
+	}
+}
+
+// The values of --region and --service are listed by running awswhois itself,
+// with the --cache-dir found on the command line so that a shared cache
+// spares a download on every TAB.
+var bashCompletion = `# bash completion for awswhois, generated by "awswhois completion bash".
+
+__awswhois_values() {
+	local cache=() i
+	for ((i = 1; i < ${#COMP_WORDS[@]} - 1; i++)); do
+		if [[ ${COMP_WORDS[i]} == --cache-dir || ${COMP_WORDS[i]} == -cache-dir ]]; then
+			cache=(--cache-dir "${COMP_WORDS[i+1]}" --cache-read-only)
+		fi
+	done
+	"${COMP_WORDS[0]}" "${cache[@]}" __complete "$1" 2>/dev/null
+}
+
+_awswhois() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} words
+	case $prev in
+	--region | -region) words=$(__awswhois_values regions) ;;
+	--service | -service) words=$(__awswhois_values services) ;;
+	--zone-type | -zone-type) words="{{.ZoneTypes}}" ;;
+	--output | -output | -o) words="{{.Outputs}} go-template= go-template-file=" ;;
+	--sort-by | -sort-by) words="{{.SortKeys}}" ;;
+	--color | -color) words="auto always never" ;;
+	--log-level | -log-level) words="debug info warn error" ;;
+	--log-format | -log-format) words="text json" ;;
+	--family | -family) words="ipv4 ipv6 all" ;;
+	--by | -by) words="region service" ;;
+	export) words="{{.Exports}}" ;;
+	completion) words="bash zsh fish" ;;
+	*)
+		if [[ $cur == -* ]]; then
+			words="{{range .Flags}}--{{.Name}} {{end}}"
+		else
+			words="{{.Subcommands}}"
+		fi
+		;;
+	esac
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+
+complete -o default -F _awswhois awswhois
+`
+
+// zsh runs the bash completion through bashcompinit rather than having a
+// script of its own.
+var zshCompletion = `#compdef awswhois
+# zsh completion for awswhois, generated by "awswhois completion zsh".
+
+autoload -U +X bashcompinit && bashcompinit
+
+` + bashCompletion
+
+var fishCompletion = `# fish completion for awswhois, generated by "awswhois completion fish".
+
+function __awswhois_values
+	set -l words (commandline -opc)
+	set -l cache
+	set -l i (contains -i -- --cache-dir $words)
+	if test -n "$i"; and test (count $words) -gt $i
+		set cache --cache-dir $words[(math $i + 1)] --cache-read-only
+	end
+	$words[1] $cache __complete $argv 2>/dev/null
+end
+
+complete -c awswhois -n __fish_use_subcommand -a '{{.Subcommands}}'
+complete -c awswhois -n '__fish_seen_subcommand_from export' -x -a '{{.Exports}}'
+complete -c awswhois -n '__fish_seen_subcommand_from completion' -x -a 'bash zsh fish'
+{{range .Flags}}complete -c awswhois {{if eq (len .Name) 1}}-s{{else}}-l{{end}} {{.Name}}{{if not .IsBool}} -r{{end}} -d '{{fishQuote .Usage}}'
+{{end}}complete -c awswhois -l region -x -a '(__awswhois_values regions)'
+complete -c awswhois -l service -x -a '(__awswhois_values services)'
+complete -c awswhois -l zone-type -x -a '{{.ZoneTypes}}'
+complete -c awswhois -l output -s o -x -a '{{.Outputs}} go-template= go-template-file='
+complete -c awswhois -l sort-by -x -a '{{.SortKeys}}'
+complete -c awswhois -l color -x -a 'auto always never'
+complete -c awswhois -l log-level -x -a 'debug info warn error'
+complete -c awswhois -l log-format -x -a 'text json'
+complete -c awswhois -l family -x -a 'ipv4 ipv6 all'
+complete -c awswhois -l by -x -a 'region service'
+`
+
+var completionScripts = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// runCompletion implements "awswhois completion <shell>", which prints the
+// completion script of bash, zsh, or fish.
+func runCompletion(args []string, out io.Writer) error {
+	if len(args) != 1 || completionScripts[args[0]] == "" {
+		return usagef("usage: %s completion bash|zsh|fish", os.Args[0])
+	}
+	tmpl, err := template.New(args[0]).Funcs(template.FuncMap{
+		"fishQuote": func(s string) string {
+			return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+		},
+	}).Parse(completionScripts[args[0]])
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(out, newCompletionData())
+}
+
+// runComplete implements the hidden "awswhois __complete regions|services"
+// that the completion scripts run. When the ranges can't be loaded, e.g.
+// offline, the regions and services known to this binary are listed instead.
+func runComplete(args []string, source rangesSource, out io.Writer) error {
+	if len(args) != 1 || args[0] != "regions" && args[0] != "services" {
+		return usagef("usage: %s __complete regions|services", os.Args[0])
+	}
+
+	var values []string
+	ranges, err := source.loadAWS()
+	switch {
+	case err == nil && args[0] == "regions":
+		values = distinctValues(ranges, func(m AWSMatch) string { return m.Region })
+	case err == nil:
+		values = distinctValues(ranges, func(m AWSMatch) string { return m.Service })
+	case args[0] == "regions":
+		values = slices.Sorted(maps.Keys(regionLocations))
+	default:
+		values = slices.Sorted(maps.Keys(serviceCatalog))
+	}
+	for _, v := range values {
+		fmt.Fprintln(out, v)
+	}
+	return nil
+}
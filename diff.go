@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// diffPrefixes returns the prefixes of after that aren't in before and the
+// ones of before that aren't in after, once the lookup filters are applied. A
+// prefix whose services changed shows up in both.
+func diffPrefixes(before, after *AWSIPRanges, opts lookupOptions) (added, removed []GroupedMatch) {
+	prefixes := func(ranges *AWSIPRanges) []GroupedMatch {
+		matches, _ := familyPrefixes(ranges, "all", opts)
+		return groupMatches(matches)
+	}
+	old, current := prefixes(before), prefixes(after)
+
+	inBefore := make(map[GroupedMatch]bool, len(old))
+	for _, group := range old {
+		inBefore[group] = true
+	}
+	inAfter := make(map[GroupedMatch]bool, len(current))
+	for _, group := range current {
+		inAfter[group] = true
+		if !inBefore[group] {
+			added = append(added, group)
+		}
+	}
+	for _, group := range old {
+		if !inAfter[group] {
+			removed = append(removed, group)
+		}
+	}
+	return added, removed
+}
+
+// readRangesFile reads a copy of ip-ranges.json.
+func readRangesFile(path string) (*AWSIPRanges, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRanges(path, body)
+}
+
+// parseRanges parses a copy of ip-ranges.json; name is where it was read
+// from, for the errors.
+func parseRanges(name string, body []byte) (*AWSIPRanges, error) {
+	var ranges AWSIPRanges
+	if err := json.Unmarshal(body, &ranges); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &ranges, nil
+}
+
+// runDiff implements "awswhois diff", which prints the prefixes added and
+// removed between a saved copy of ip-ranges.json and another one, or the
+// current ranges when only one file is given.
+func runDiff(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] diff <old.json> [<new.json>]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return usagef("diff takes one or two files")
+	}
+
+	before, err := readRangesFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var after *AWSIPRanges
+	if fs.NArg() == 2 {
+		after, err = readRangesFile(fs.Arg(1))
+	} else {
+		after, err = source.loadAWS()
+	}
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	before.excludePrefixes(source.ExcludePrefixes)
+	after.excludePrefixes(source.ExcludePrefixes)
+	if opts, err = opts.resolveNames(after); err != nil {
+		return err
+	}
+
+	added, removed := diffPrefixes(before, after, opts)
+	fmt.Fprintf(out, "%s -> %s: %d added, %d removed\n", before.CreateDate, after.CreateDate, len(added), len(removed))
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tPREFIX\tREGION\tSERVICE\tBORDER GROUP\tZONE TYPE")
+	for _, group := range removed {
+		fmt.Fprintf(w, "-\t%s\t%s\t%s\t%s\t%s\n", group.Prefix, group.Region, group.Services, group.NetworkBorderGroup, zoneLabel(group))
+	}
+	for _, group := range added {
+		fmt.Fprintf(w, "+\t%s\t%s\t%s\t%s\t%s\n", group.Prefix, group.Region, group.Services, group.NetworkBorderGroup, zoneLabel(group))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+)
+
+// readPrefixesFile reads the CIDRs given with --exclude-prefixes, one per
+// line. Blank lines and "#" comments are ignored.
+func readPrefixesFile(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines, err := readTargets(f)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	for _, line := range lines {
+		prefix, err := parseCustomPrefix(line.Text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", path, line.Num, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// excludePrefixes removes the given prefixes from the ranges, including the
+// custom ones. Only the prefixes listed are removed, not the ones they
+// contain: excluding the 3.0.0.0/9 AMAZON aggregate keeps the more specific
+// prefixes carved out of it.
+func (r *AWSIPRanges) excludePrefixes(excluded []netip.Prefix) {
+	if len(excluded) == 0 {
+		return
+	}
+	isExcluded := func(s string) bool {
+		prefix, err := netip.ParsePrefix(s)
+		return err == nil && slices.Contains(excluded, prefix.Masked())
+	}
+	r.Prefixes = slices.DeleteFunc(r.Prefixes, func(p IPPrefix) bool { return isExcluded(p.IPPrefix) })
+	r.IPv6Prefixes = slices.DeleteFunc(r.IPv6Prefixes, func(p IPv6Prefix) bool { return isExcluded(p.IPv6Prefix) })
+	r.Custom = slices.DeleteFunc(r.Custom, func(p CustomPrefix) bool { return slices.Contains(excluded, p.Prefix) })
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// extractIPsFromCommand runs the given command through the shell and returns
+// the distinct IPs found in its output, in order of appearance. This lets
+// users compose things like 'ss -tn' or 'netstat -an' with awswhois.
+func extractIPsFromCommand(command string) ([]string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return extractIPs(out), nil
+}
+
+// extractIPs finds the IPv4 and IPv6 addresses in free-form text. Ports are
+// dropped, whether written as 10.0.0.1:22 or [2600:1f18::1]:443.
+func extractIPs(text []byte) []string {
+	fields := bytes.FieldsFunc(text, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F' ||
+			r == '.' || r == ':' || r == '[' || r == ']' || r == '*')
+	})
+
+	var ips []string
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		token := strings.TrimRight(string(field), ".:")
+
+		ip := net.ParseIP(strings.Trim(token, "[]"))
+		if ip == nil {
+			host, _, err := net.SplitHostPort(token)
+			if err != nil {
+				continue
+			}
+			ip = net.ParseIP(host)
+		}
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
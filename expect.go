@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// parseExpect parses the value of --expect, e.g. "region=eu-west-1,service=S3".
+// The keys are the fields of the assert rules.
+func parseExpect(s string) ([]rule, error) {
+	var rules []rule
+	for _, item := range splitList(s) {
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("%q must be of the form key=value", item)
+		}
+		if !slices.Contains(ruleFields, key) {
+			return nil, fmt.Errorf("unknown key %q, must be one of: %s", key, strings.Join(ruleFields, ", "))
+		}
+		rules = append(rules, rule{Text: key + "=" + value, Field: key, Values: []string{value}})
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no expectation given")
+	}
+	return rules, nil
+}
+
+// checkExpect writes a diff to w for each IP that has no match satisfying all
+// the expectations at once, and returns false if there is any.
+func checkExpect(w io.Writer, results []Result, expect []rule) bool {
+	ok := true
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(w, "Expectation not met for %s: %v\n", result.Target.Input, result.Err)
+			ok = false
+			continue
+		}
+		for _, subject := range result.Subjects {
+			missing, split := unmetExpectations(subject.Matches, expect)
+			if len(missing) == 0 {
+				continue
+			}
+			ok = false
+			fmt.Fprintf(w, "Expectation not met for %s:\n", subject.Label)
+			if len(subject.Matches) == 0 {
+				for _, r := range expect {
+					fmt.Fprintf(w, "  - %s\n", r.Text)
+				}
+				fmt.Fprintf(w, "  + not AWS\n")
+				continue
+			}
+			if split {
+				fmt.Fprintf(w, "  (no single prefix has all of the expected values)\n")
+			}
+			for _, r := range missing {
+				fmt.Fprintf(w, "  - %s\n", r.Text)
+				fmt.Fprintf(w, "  + %s=%s\n", r.Field, strings.Join(fieldValues(subject.Matches, r.Field), ","))
+			}
+		}
+	}
+	return ok
+}
+
+// unmetExpectations returns the expectations whose value none of the matches
+// has. When each of them is found, but on different prefixes, it returns all
+// of them and split is true. It returns none when a single match has them all.
+func unmetExpectations(matches []AWSMatch, expect []rule) (missing []rule, split bool) {
+	met := slices.ContainsFunc(matches, func(m AWSMatch) bool {
+		for _, r := range expect {
+			if !r.matches(m) {
+				return false
+			}
+		}
+		return true
+	})
+	if met {
+		return nil, false
+	}
+	for _, r := range expect {
+		if !slices.ContainsFunc(matches, r.matches) {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) == 0 {
+		return expect, true
+	}
+	return missing, false
+}
+
+// fieldValues returns the distinct values of a rule field among the matches.
+func fieldValues(matches []AWSMatch, field string) []string {
+	var values []string
+	for _, m := range matches {
+		if v := fieldValue(m, field); !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// checkAWSGate implements --fail-if-not-aws when wantAWS is true and
+// --fail-if-aws otherwise. It writes each IP on the wrong side to w and
+// returns false if there is any. The targets that couldn't be looked up are
+// left to the exit code.
+func checkAWSGate(w io.Writer, results []Result, wantAWS bool) bool {
+	ok := true
+	for _, result := range results {
+		for _, subject := range result.Subjects {
+			inAWS := len(subject.Matches) > 0
+			switch {
+			case wantAWS && !inAWS:
+				fmt.Fprintf(w, "%s is not in AWS\n", subject.Label)
+				ok = false
+			case !wantAWS && inAWS:
+				fmt.Fprintf(w, "%s is in AWS: %s\n", subject.Label, groupMatches(subject.Matches)[0].Prefix)
+				ok = false
+			}
+		}
+	}
+	return ok
+}
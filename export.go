@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exporters are the formats of "awswhois export". Each one parses its own
+// flags on top of the ones added by newExportRequest.
+var exporters = map[string]func(args []string, source rangesSource, opts lookupOptions, out io.Writer) error{
+	"ansible":           exportAnsible,
+	"aws-json":          exportAWSJSON,
+	"bird":              exportBIRD,
+	"cidr":              exportCIDR,
+	"cisco-acl":         exportCiscoACL,
+	"frr":               exportFRR,
+	"haproxy":           exportHAProxy,
+	"ipset":             exportIPSet,
+	"junos-prefix-list": exportJunosPrefixList,
+	"pf":                exportPF,
+	"squid":             exportSquid,
+}
+
+// runExport implements "awswhois export <format>", which writes the AWS
+// prefixes selected by --service, --region, and --zone-type in a format that
+// firewalls, proxies, and routers can load.
+func runExport(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	formats := slices.Sorted(maps.Keys(exporters))
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] export <format> [format flags]\n\nFormats: %s\n", os.Args[0], strings.Join(formats, ", "))
+		return usagef("no format given")
+	}
+	export, ok := exporters[args[0]]
+	if !ok {
+		return usagef("unknown format %q, must be one of: %s", args[0], strings.Join(formats, ", "))
+	}
+	return export(args[1:], source, opts, out)
+}
+
+// exportRequest holds the flags that every export format has. --service and
+// --region can be given after the format too, which reads better in scripts.
+type exportRequest struct {
+	source      rangesSource
+	opts        lookupOptions
+	family      *string
+	aggregate   *bool
+	serviceList *string
+	regionList  *string
+
+	// ranges are the ranges the prefixes were selected from, once loaded.
+	ranges *AWSIPRanges
+}
+
+// newExportRequest adds the common flags to fs. The formats that keep the
+// region and border group of each prefix can't aggregate them and don't get
+// --aggregate.
+func newExportRequest(fs *flag.FlagSet, source rangesSource, opts lookupOptions, canAggregate bool) *exportRequest {
+	r := &exportRequest{
+		source:      source,
+		opts:        opts,
+		family:      fs.String("family", "all", "only export the prefixes of this address family (ipv4, ipv6, all)"),
+		aggregate:   new(bool),
+		serviceList: fs.String("service", "", "only export the prefixes of these comma-separated services, as with the global --service"),
+		regionList:  fs.String("region", "", "only export the prefixes of these comma-separated regions, as with the global --region"),
+	}
+	if canAggregate {
+		r.aggregate = fs.Bool("aggregate", true, "merge adjacent prefixes and drop the ones covered by a larger one, to keep the number of entries low")
+	}
+	return r
+}
+
+// selected loads the ranges and returns them along with the prefixes that the
+// flags select.
+func (r *exportRequest) selected() (*AWSIPRanges, []AWSMatch, error) {
+	ranges, err := r.source.load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	r.ranges = ranges
+	if *r.serviceList != "" {
+		r.opts.Services = splitList(*r.serviceList)
+	}
+	if *r.regionList != "" {
+		r.opts.Regions = splitList(*r.regionList)
+	}
+	opts, err := r.opts.resolveNames(ranges)
+	if err != nil {
+		return nil, nil, err
+	}
+	matches, err := familyPrefixes(ranges, *r.family, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ranges, matches, nil
+}
+
+// exportGroup is the set of prefixes of one service in one region.
+type exportGroup struct {
+	Service  string
+	Region   string
+	Prefixes []netip.Prefix
+}
+
+// groups returns the selected prefixes per (service, region) pair, sorted by
+// service then region.
+func (r *exportRequest) groups() ([]exportGroup, error) {
+	_, matches, err := r.selected()
+	if err != nil {
+		return nil, err
+	}
+
+	type pair struct{ Service, Region string }
+	byPair := make(map[pair][]netip.Prefix)
+	for _, m := range matches {
+		p, err := netip.ParsePrefix(m.Prefix)
+		if err != nil {
+			continue
+		}
+		k := pair{m.Service, m.Region}
+		byPair[k] = append(byPair[k], p.Masked())
+	}
+
+	var groups []exportGroup
+	for k, prefixes := range byPair {
+		groups = append(groups, exportGroup{Service: k.Service, Region: k.Region, Prefixes: r.minimize(prefixes)})
+	}
+	slices.SortFunc(groups, func(a, b exportGroup) int {
+		if c := strings.Compare(a.Service, b.Service); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Region, b.Region)
+	})
+	return groups, nil
+}
+
+// services returns the selected prefixes per service, all regions together.
+func (r *exportRequest) services() ([]exportGroup, error) {
+	groups, err := r.groups()
+	if err != nil {
+		return nil, err
+	}
+	var services []exportGroup
+	for _, g := range groups {
+		if n := len(services); n > 0 && services[n-1].Service == g.Service {
+			services[n-1].Prefixes = append(services[n-1].Prefixes, g.Prefixes...)
+			continue
+		}
+		services = append(services, exportGroup{Service: g.Service, Prefixes: slices.Clone(g.Prefixes)})
+	}
+	for i := range services {
+		services[i].Prefixes = r.minimize(services[i].Prefixes)
+	}
+	return services, nil
+}
+
+// header tells where the prefixes come from, for the formats that allow
+// comments.
+func (r *exportRequest) header() string {
+	return fmt.Sprintf("Generated by awswhois from ip-ranges.json %s (syncToken %s)", r.ranges.CreateDate, r.ranges.SyncToken)
+}
+
+// prefixes returns the selected prefixes of all the groups as one list.
+func (r *exportRequest) prefixes() ([]netip.Prefix, error) {
+	groups, err := r.groups()
+	if err != nil {
+		return nil, err
+	}
+	var all []netip.Prefix
+	for _, g := range groups {
+		all = append(all, g.Prefixes...)
+	}
+	return r.minimize(all), nil
+}
+
+// minimize sorts the prefixes and removes the duplicates, or aggregates them
+// unless --aggregate=false was given.
+func (r *exportRequest) minimize(prefixes []netip.Prefix) []netip.Prefix {
+	if *r.aggregate {
+		return aggregatePrefixes(prefixes)
+	}
+	slices.SortFunc(prefixes, comparePrefixes)
+	return slices.Compact(prefixes)
+}
+
+func comparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// aggregatePrefixes returns the smallest list of prefixes that covers the
+// same addresses: the prefixes covered by a larger one are dropped, and two
+// halves of the same block are merged into it.
+func aggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	sorted := slices.Clone(prefixes)
+	slices.SortFunc(sorted, comparePrefixes)
+
+	var out []netip.Prefix
+	for _, p := range sorted {
+		if n := len(out); n > 0 && out[n-1].Bits() <= p.Bits() && out[n-1].Contains(p.Addr()) {
+			continue
+		}
+		out = append(out, p)
+		for len(out) >= 2 {
+			a, b := out[len(out)-2], out[len(out)-1]
+			if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() {
+				break
+			}
+			parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+			if parent.Addr() != a.Addr() || !parent.Contains(b.Addr()) {
+				break
+			}
+			out = append(out[:len(out)-2], parent)
+		}
+	}
+	return out
+}
+
+// exportCIDR writes one prefix per line. By default, each (service, region)
+// pair has its own minimal list under a comment; --flat writes a single list
+// for all of them.
+func exportCIDR(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export cidr", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	flat := fs.Bool("flat", false, "write a single list instead of one per (service, region) pair")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *flat {
+		prefixes, err := req.prefixes()
+		if err != nil {
+			return err
+		}
+		for _, p := range prefixes {
+			fmt.Fprintln(out, p)
+		}
+		return nil
+	}
+
+	groups, err := req.groups()
+	if err != nil {
+		return err
+	}
+	for i, g := range groups {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "# %s %s\n", g.Service, g.Region)
+		for _, p := range g.Prefixes {
+			fmt.Fprintln(out, p)
+		}
+	}
+	return nil
+}
+
+// prefixListEntry is an entry of an EC2 managed prefix list, as given to
+// "aws ec2 create-managed-prefix-list --entries".
+type prefixListEntry struct {
+	Cidr        string `json:"Cidr"`
+	Description string `json:"Description"`
+}
+
+// exportAWSJSON writes the selected prefixes in the format of ip-ranges.json,
+// with the syncToken and createDate of the ranges they were taken from, so
+// that tools reading that file, awswhois included, can use the extract. With
+// --prefix-list, it writes the entries of a managed prefix list instead.
+func exportAWSJSON(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export aws-json", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, false)
+	prefixList := fs.Bool("prefix-list", false, "write the entries of an EC2 managed prefix list, for --entries file://..., instead of an ip-ranges.json extract")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	ranges, matches, err := req.selected()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+
+	if *prefixList {
+		// A prefix list entry has a single description, so the services
+		// sharing a prefix are listed together.
+		entries := []prefixListEntry{}
+		for _, group := range groupMatches(matches) {
+			entries = append(entries, prefixListEntry{group.Prefix, group.Services + " " + group.NetworkBorderGroup})
+		}
+		return enc.Encode(entries)
+	}
+
+	extract := AWSIPRanges{
+		SyncToken:    ranges.SyncToken,
+		CreateDate:   ranges.CreateDate,
+		Prefixes:     []IPPrefix{},
+		IPv6Prefixes: []IPv6Prefix{},
+	}
+	for _, m := range matches {
+		if strings.Contains(m.Prefix, ":") {
+			extract.IPv6Prefixes = append(extract.IPv6Prefixes, IPv6Prefix{m.Prefix, m.Region, m.Service, m.NetworkBorderGroup})
+		} else {
+			extract.Prefixes = append(extract.Prefixes, IPPrefix{m.Prefix, m.Region, m.Service, m.NetworkBorderGroup})
+		}
+	}
+	return enc.Encode(extract)
+}
+
+// exportAnsible writes the selected prefixes as Ansible variables: a vars
+// file in YAML, or with --inventory the JSON of a dynamic inventory, where
+// the variables apply to the "all" group. <prefix>_prefixes lists all the
+// prefixes and <prefix>_prefixes_by_service those of each service.
+func exportAnsible(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export ansible", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	prefix := fs.String("var-prefix", "aws", "prefix of the variable names")
+	inventory := fs.Bool("inventory", false, "write the JSON of a dynamic inventory instead of a YAML vars file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	services, err := req.services()
+	if err != nil {
+		return err
+	}
+	strs := func(prefixes []netip.Prefix) []string {
+		s := make([]string, 0, len(prefixes))
+		for _, p := range prefixes {
+			s = append(s, p.String())
+		}
+		return s
+	}
+	var all []netip.Prefix
+	byService := make(map[string][]string)
+	for _, svc := range services {
+		all = append(all, svc.Prefixes...)
+		byService[svc.Service] = strs(svc.Prefixes)
+	}
+	vars := map[string]any{
+		*prefix + "_ip_ranges_create_date": req.ranges.CreateDate,
+		*prefix + "_ip_ranges_sync_token":  req.ranges.SyncToken,
+		*prefix + "_prefixes":              strs(req.minimize(all)),
+		*prefix + "_prefixes_by_service":   byService,
+	}
+
+	if *inventory {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]any{
+			"all":   map[string]any{"vars": vars},
+			"_meta": map[string]any{"hostvars": map[string]any{}},
+		})
+	}
+	fmt.Fprintf(out, "# %s\n", req.header())
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(vars); err != nil {
+		return err
+	}
+	return enc.Close()
+}
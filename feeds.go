@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CustomPrefix is a prefix listed in one of the feeds given with --feed, such
+// as the ranges of a corporate VPN or of a partner network.
+type CustomPrefix struct {
+	Prefix netip.Prefix
+	Label  string
+	Feed   string
+}
+
+// feed is an additional list of labelled CIDRs matched alongside the AWS
+// prefixes. Source is a path or an http(s) URL.
+type feed struct {
+	Name   string
+	Source string
+}
+
+// parseFeed parses the value of --feed, e.g. "corp=./corp.csv".
+func parseFeed(s string) (feed, error) {
+	name, source, ok := strings.Cut(s, "=")
+	if !ok || name == "" || source == "" {
+		return feed{}, fmt.Errorf("%q must be of the form name=path or name=url", s)
+	}
+	return feed{Name: name, Source: source}, nil
+}
+
+// extraRangesFeed returns the feed for a file given with --extra-ranges. It is
+// named after the file, e.g. "ours" for ours.yaml.
+func extraRangesFeed(path string) feed {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return feed{Name: name, Source: path}
+}
+
+// parse parses the content of the feed. All the formats carry a CIDR and a
+// label per entry:
+//
+//	[{"cidr": "10.0.0.0/8", "label": "corp-vpn"}]
+//
+//	# comments and a "cidr,label" header are allowed
+//	10.0.0.0/8,corp-vpn
+//
+//	# YAML (.yaml or .yml), mapping CIDRs to labels
+//	10.0.0.0/8: corp-vpn
+//
+// A bare IP address is taken as a /32 or /128.
+func (f feed) parse(data []byte) ([]CustomPrefix, error) {
+	type entry struct {
+		CIDR  string `json:"cidr"`
+		Label string `json:"label"`
+	}
+	var entries []entry
+	if ext := strings.ToLower(path.Ext(f.Source)); ext == ".yaml" || ext == ".yml" {
+		// Decoding into a node keeps the entries in the order of the file.
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("feed %s: %w", f.Name, err)
+		}
+		if len(doc.Content) > 0 {
+			m := doc.Content[0]
+			if m.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("feed %s: line %d: expected a mapping of CIDRs to labels", f.Name, m.Line)
+			}
+			for i := 0; i+1 < len(m.Content); i += 2 {
+				if m.Content[i+1].Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("feed %s: line %d: the label of %s must be a string", f.Name, m.Content[i+1].Line, m.Content[i].Value)
+				}
+				entries = append(entries, entry{CIDR: m.Content[i].Value, Label: m.Content[i+1].Value})
+			}
+		}
+	} else if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("feed %s: %w", f.Name, err)
+		}
+	} else {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.Comment = '#'
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("feed %s: %w", f.Name, err)
+		}
+		for i, record := range records {
+			if i == 0 && strings.EqualFold(record[0], "cidr") {
+				continue
+			}
+			e := entry{CIDR: record[0]}
+			if len(record) > 1 {
+				e.Label = record[1]
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	var prefixes []CustomPrefix
+	for i, e := range entries {
+		prefix, err := parseCustomPrefix(strings.TrimSpace(e.CIDR))
+		if err != nil {
+			return nil, fmt.Errorf("feed %s: entry %d: %w", f.Name, i+1, err)
+		}
+		label := strings.TrimSpace(e.Label)
+		if label == "" {
+			label = f.Name
+		}
+		prefixes = append(prefixes, CustomPrefix{Prefix: prefix, Label: label, Feed: f.Name})
+	}
+	return prefixes, nil
+}
+
+// readFeed reads the file of the feed or downloads its URL, with the same
+// timeout and retries as the ranges.
+func (s rangesSource) readFeed(ctx context.Context, f feed) (data []byte, err error) {
+	if !strings.HasPrefix(f.Source, "http://") && !strings.HasPrefix(f.Source, "https://") {
+		return os.ReadFile(f.Source)
+	}
+	err = s.withRetries(ctx, "downloading feed "+f.Name, func(ctx context.Context) error {
+		data, err = fetchFeed(ctx, f.Source)
+		return err
+	})
+	return data, err
+}
+
+func fetchFeed(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func parseCustomPrefix(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.Prefix{}, errors.New("not a CIDR block or an IP: " + s)
+}
+
+// loadFeeds loads all the feeds. A feed that can't be loaded fails the whole
+// load: silently reporting its addresses as unknown would be misleading.
+func (s rangesSource) loadFeeds(ctx context.Context) ([]CustomPrefix, error) {
+	var prefixes []CustomPrefix
+	for _, f := range s.Feeds {
+		data, err := s.readFeed(ctx, f)
+		if err != nil {
+			return nil, fmt.Errorf("feed %s: %w", f.Name, err)
+		}
+		p, err := f.parse(data)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p...)
+	}
+	return prefixes, nil
+}
+
+// findCustomMatches returns the custom prefixes that overlap the given block,
+// which is a single address for IP targets. They are reported like AWS
+// matches, with the label as the service and the feed as the provider.
+func findCustomMatches(block netip.Prefix, ranges *AWSIPRanges) []AWSMatch {
+	var matches []AWSMatch
+	for _, custom := range ranges.Custom {
+		if custom.Prefix.Overlaps(block) {
+			matches = append(matches, AWSMatch{
+				Prefix:             custom.Prefix.String(),
+				Region:             "-",
+				Service:            custom.Label,
+				NetworkBorderGroup: "-",
+				Feed:               custom.Feed,
+			})
+		}
+	}
+	return matches
+}
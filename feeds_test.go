@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadFeedsFromURL(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		switch r.URL.Path {
+		case "/flaky.csv":
+			if n == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("cidr,label\n10.0.0.0/8,corp-vpn\n"))
+		case "/hangs.csv":
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		source  string
+		want    []CustomPrefix
+		wantErr bool
+	}{
+		{
+			name:   "retried after a 503",
+			source: srv.URL + "/flaky.csv",
+			want:   []CustomPrefix{{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Label: "corp-vpn", Feed: "corp"}},
+		},
+		{
+			name:    "timed out",
+			source:  srv.URL + "/hangs.csv",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			s := rangesSource{Timeout: 100 * time.Millisecond, Retries: 1, Feeds: []feed{{Name: "corp", Source: tt.source}}}
+			got, err := s.loadFeeds(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
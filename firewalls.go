@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// exportPF writes a pf table file, one prefix per line, as loaded by a
+// "table <aws> persist file" line in pf.conf. With --apply, the table of the
+// running pf is replaced from the file with pfctl, so that the new prefixes
+// apply without reloading the ruleset.
+func exportPF(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export pf", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	table := fs.String("table", "aws", "name of the pf table")
+	file := fs.String("file", "", "write the table to this file, atomically, instead of stdout")
+	apply := fs.Bool("apply", false, "with --file, run 'pfctl -t <table> -T replace -f <file>' once the file is written")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *apply && *file == "" {
+		return usagef("--apply requires --file")
+	}
+
+	prefixes, err := req.prefixes()
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "# %s\n", req.header())
+	for _, p := range prefixes {
+		fmt.Fprintln(&body, p)
+	}
+	if *file == "" {
+		_, err := out.Write(body.Bytes())
+		return err
+	}
+
+	path, err := filepath.Abs(*file)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, body.Bytes()); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "table <%s> persist file %q\n", *table, path)
+	if !*apply {
+		return nil
+	}
+	cmd := exec.Command("pfctl", "-t", *table, "-T", "replace", "-f", path)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("replacing pf table %s: %w", *table, err)
+	}
+	return nil
+}
+
+// dnsmasqDomains gives the domains whose answers are addresses of the
+// prefixes of a service, for the dnsmasq ipset= option. The services reached
+// through the API endpoints of other services, such as EC2, have none.
+var dnsmasqDomains = map[string]func(region string) []string{
+	"S3": func(region string) []string {
+		if region == "us-east-1" {
+			return []string{"s3.amazonaws.com", "s3.us-east-1.amazonaws.com"}
+		}
+		return []string{"s3." + region + ".amazonaws.com"}
+	},
+	"DYNAMODB":   func(region string) []string { return []string{"dynamodb." + region + ".amazonaws.com"} },
+	"CLOUDFRONT": func(string) []string { return []string{"cloudfront.net"} },
+}
+
+// exportIPSet writes a script for "ipset restore" that fills a temporary set
+// and swaps it with the live one, so that the rules using the set never see
+// it half-filled. IPv6 prefixes go to a second set suffixed with -v6.
+func exportIPSet(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export ipset", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	name := fs.String("name", "aws", "name of the set; the IPv6 one gets a -v6 suffix")
+	dnsmasqConf := fs.String("dnsmasq-conf", "", "also write a dnsmasq ipset= line to this file, so that the addresses the service domains resolve to are added to the sets too (S3, DYNAMODB, and CLOUDFRONT)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	// ipset names are limited to 31 characters, including the suffixes.
+	if len(*name)+len("-v6.tmp") > 31 {
+		return usagef("--name %q is too long, ipset names can't be longer than 31 characters and %q needs room for -v6.tmp", *name, *name)
+	}
+
+	groups, err := req.groups()
+	if err != nil {
+		return err
+	}
+	var all []netip.Prefix
+	for _, g := range groups {
+		all = append(all, g.Prefixes...)
+	}
+	v4, v6 := splitFamilies(req.minimize(all))
+
+	fmt.Fprintf(out, "# %s\n", req.header())
+	for _, set := range []struct {
+		name, family string
+		prefixes     []netip.Prefix
+	}{{*name, "inet", v4}, {*name + "-v6", "inet6", v6}} {
+		if len(set.prefixes) == 0 {
+			continue
+		}
+		tmp := set.name + ".tmp"
+		maxElem := max(65536, len(set.prefixes))
+		fmt.Fprintf(out, "create %s hash:net family %s maxelem %d -exist\n", set.name, set.family, maxElem)
+		fmt.Fprintf(out, "create %s hash:net family %s maxelem %d -exist\n", tmp, set.family, maxElem)
+		fmt.Fprintf(out, "flush %s\n", tmp)
+		for _, p := range set.prefixes {
+			fmt.Fprintf(out, "add %s %s\n", tmp, p)
+		}
+		fmt.Fprintf(out, "swap %s %s\n", tmp, set.name)
+		fmt.Fprintf(out, "destroy %s\n", tmp)
+	}
+
+	if *dnsmasqConf == "" {
+		return nil
+	}
+	var domains []string
+	for _, g := range groups {
+		domain, ok := dnsmasqDomains[g.Service]
+		if !ok {
+			continue
+		}
+		for _, d := range domain(g.Region) {
+			if !slices.Contains(domains, d) {
+				domains = append(domains, d)
+			}
+		}
+	}
+	if len(domains) == 0 {
+		return errors.New("--dnsmasq-conf: none of the selected services has domains that resolve to its prefixes")
+	}
+	sets := *name
+	if len(v6) > 0 {
+		sets += "," + *name + "-v6"
+	}
+	conf := fmt.Sprintf("# %s\nipset=/%s/%s\n", req.header(), strings.Join(domains, "/"), sets)
+	return writeFileAtomic(*dnsmasqConf, []byte(conf))
+}
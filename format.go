@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jmespath/go-jmespath"
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormats are the values of --output, besides go-template=<template>
+// and go-template-file=<file>.
+var outputFormats = []string{"table", "json", "ndjson", "yaml", "csv", "tsv", "github", "junit", "nagios"}
+
+// outputFormat is a parsed --output. Template is set for go-template, and
+// Field for the "print" format that --print selects.
+type outputFormat struct {
+	Name     string
+	Template *template.Template
+	Field    string
+}
+
+// printFields are the values of --print.
+var printFields = []string{"region", "service", "prefix"}
+
+func parseOutput(s string) (outputFormat, error) {
+	name, arg, _ := strings.Cut(s, "=")
+	switch name {
+	case "go-template", "go-template-file":
+		text := arg
+		if name == "go-template-file" {
+			data, err := os.ReadFile(arg)
+			if err != nil {
+				return outputFormat{}, err
+			}
+			text = string(data)
+		}
+		if text == "" {
+			return outputFormat{}, fmt.Errorf("%s needs a template, e.g. %s='{{.IP}} {{.Region}}'", name, name)
+		}
+		tmpl, err := template.New("output").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return outputFormat{}, err
+		}
+		return outputFormat{Name: "go-template", Template: tmpl}, nil
+	}
+	if !slices.Contains(outputFormats, s) {
+		return outputFormat{}, fmt.Errorf("unknown output %q, must be one of: %s, go-template=..., go-template-file=...", s, strings.Join(outputFormats, ", "))
+	}
+	return outputFormat{Name: s}, nil
+}
+
+// isJSON tells whether the format is json or ndjson, which report the errors
+// as JSON too.
+func (f outputFormat) isJSON() bool {
+	return f.Name == "json" || f.Name == "ndjson"
+}
+
+// inBandErrors tells whether the format reports a target that couldn't be
+// looked up as one of the results, even when it is the only target.
+func (f outputFormat) inBandErrors() bool {
+	return f.isJSON() || f.Name == "github" || f.Name == "junit" || f.Name == "nagios"
+}
+
+// streamed tells whether streamResult writes the results of the format as
+// they come, rather than writeResults once they are all known.
+func (f outputFormat) streamed() bool {
+	switch f.Name {
+	case "ndjson", "go-template", "print", "github":
+		return true
+	}
+	return false
+}
+
+// templateMatch is what a go-template is executed with, once per match.
+// Service holds the services of the prefix joined with commas, as in the
+// table.
+type templateMatch struct {
+	Target             string
+	IP                 string
+	Prefix             string
+	Region             string
+	Service            string
+	Services           []string
+	NetworkBorderGroup string
+	ZoneType           ZoneType
+	Feed               string
+	Tags               map[string]string
+}
+
+// executeTemplate writes the template once per match, each time followed by
+// a newline unless the template ends with one. The IPs without matches write
+// nothing, and the errors go to errOut.
+func executeTemplate(out, errOut io.Writer, tmpl *template.Template, records []resultRecord) error {
+	for _, r := range records {
+		if r.Error != "" {
+			fmt.Fprintf(errOut, "Error: %s: %s\n", r.Target, r.Error)
+			continue
+		}
+		for _, m := range r.Matches {
+			var buf bytes.Buffer
+			err := tmpl.Execute(&buf, templateMatch{
+				Target:             r.Target,
+				IP:                 r.IP,
+				Prefix:             m.Prefix,
+				Region:             m.Region,
+				Service:            strings.Join(m.Services, ","),
+				Services:           m.Services,
+				NetworkBorderGroup: m.NetworkBorderGroup,
+				ZoneType:           m.ZoneType,
+				Feed:               m.Feed,
+				Tags:               m.Tags,
+			})
+			if err != nil {
+				return err
+			}
+			if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+				buf.WriteByte('\n')
+			}
+			if _, err := out.Write(buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printValue returns the field of the most specific AWS match of the first IP
+// of the result that has one, and false when none has. Every prefix is also
+// listed under the AMAZON service, so it is only the service when there is no
+// other.
+func printValue(result Result, field string) (string, bool) {
+	for _, subject := range result.Subjects {
+		groups := groupMatches(subject.Matches)
+		if len(groups) == 0 {
+			continue
+		}
+		best := groups[len(groups)-1]
+		switch field {
+		case "region":
+			return best.Region, true
+		case "prefix":
+			return best.Prefix, true
+		}
+		services := strings.Split(best.Services, ",")
+		if i := slices.IndexFunc(services, func(s string) bool { return s != "AMAZON" }); i >= 0 {
+			return services[i], true
+		}
+		return services[0], true
+	}
+	return "", false
+}
+
+// resultRecord is the result for one IP, or one CIDR block of an IP range, in
+// the machine-readable outputs. A target that couldn't be looked up has a
+// single record with Error set.
+type resultRecord struct {
+	Target  string        `json:"target" yaml:"target"`
+	IP      string        `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Matches []matchRecord `json:"matches" yaml:"matches"`
+	Error   string        `json:"error,omitempty" yaml:"error,omitempty"`
+
+	// Class is what the IP is when it has no match and is private or
+	// reserved, e.g. "PRIVATE (RFC1918)".
+	Class string `json:"class,omitempty" yaml:"class,omitempty"`
+
+	// ErrorCode is one of the errCode constants, e.g. "resolve".
+	ErrorCode string `json:"error_code,omitempty" yaml:"error_code,omitempty"`
+}
+
+type matchRecord struct {
+	Prefix             string            `json:"prefix" yaml:"prefix"`
+	Overlap            string            `json:"overlap,omitempty" yaml:"overlap,omitempty"`
+	Region             string            `json:"region" yaml:"region"`
+	Services           []string          `json:"services" yaml:"services"`
+	NetworkBorderGroup string            `json:"network_border_group" yaml:"network_border_group"`
+	ZoneType           ZoneType          `json:"zone_type" yaml:"zone_type"`
+	Feed               string            `json:"feed,omitempty" yaml:"feed,omitempty"`
+	Latitude           *float64          `json:"latitude,omitempty" yaml:"latitude,omitempty"`
+	Longitude          *float64          `json:"longitude,omitempty" yaml:"longitude,omitempty"`
+	Country            string            `json:"country,omitempty" yaml:"country,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// errorRecord is how the JSON outputs report, on stderr, an error that stops
+// the lookup before any result.
+type errorRecord struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeErrorRecord(w io.Writer, record errorRecord) {
+	json.NewEncoder(w).Encode(struct {
+		Error errorRecord `json:"error"`
+	}{record})
+}
+
+// metadataRecord describes the AWS IP ranges the results come from, so that
+// their consumers can tell how fresh they are.
+type metadataRecord struct {
+	SyncToken  string    `json:"sync_token" yaml:"sync_token"`
+	CreateDate string    `json:"create_date" yaml:"create_date"`
+	FetchedAt  time.Time `json:"fetched_at" yaml:"fetched_at"`
+	FromCache  bool      `json:"from_cache" yaml:"from_cache"`
+	Embedded   bool      `json:"embedded,omitempty" yaml:"embedded,omitempty"`
+}
+
+func newMetadataRecord(ranges *AWSIPRanges) metadataRecord {
+	return metadataRecord{
+		SyncToken:  ranges.SyncToken,
+		CreateDate: ranges.CreateDate,
+		FetchedAt:  ranges.FetchedAt,
+		FromCache:  ranges.FromCache,
+		Embedded:   ranges.Embedded,
+	}
+}
+
+// document returns what json and yaml encode: the records, or with
+// --metadata an object holding the metadata and the records.
+func document(results []Result, ranges *AWSIPRanges, opts tableOptions) any {
+	records := sortedRecords(results, opts)
+	if !opts.Metadata {
+		return records
+	}
+	return struct {
+		Metadata metadataRecord `json:"metadata" yaml:"metadata"`
+		Results  []resultRecord `json:"results" yaml:"results"`
+	}{newMetadataRecord(ranges), records}
+}
+
+// queriedDocument returns the document, or what --query extracts from it.
+func queriedDocument(results []Result, ranges *AWSIPRanges, opts tableOptions) (any, error) {
+	doc := document(results, ranges, opts)
+	if opts.Query == nil {
+		return doc, nil
+	}
+	return applyQuery(opts.Query, doc)
+}
+
+// applyQuery runs the --query on the document. JMESPath works on decoded JSON
+// rather than on Go structs, so the document is encoded and decoded first.
+func applyQuery(query *jmespath.JMESPath, doc any) (any, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return query.Search(decoded)
+}
+
+// streamMetadata writes the line that starts the ndjson output with
+// --metadata, before any result.
+func streamMetadata(out io.Writer, format outputFormat, ranges *AWSIPRanges, opts tableOptions) error {
+	if format.Name != "ndjson" || !opts.Metadata {
+		return nil
+	}
+	return json.NewEncoder(out).Encode(struct {
+		Metadata metadataRecord `json:"metadata"`
+	}{newMetadataRecord(ranges)})
+}
+
+// resultRecords flattens the results into one record per IP, with the
+// matches grouped as in the table.
+func resultRecords(results []Result, a annotations) []resultRecord {
+	records := []resultRecord{}
+	for _, result := range results {
+		if result.Err != nil {
+			records = append(records, resultRecord{
+				Target:    result.Target.Input,
+				Matches:   []matchRecord{},
+				Error:     result.Err.Error(),
+				ErrorCode: errorCode(result.Err),
+			})
+			continue
+		}
+		for _, subject := range result.Subjects {
+			record := resultRecord{Target: result.Target.Input, IP: subject.Label, Matches: []matchRecord{}}
+			for _, group := range groupMatches(slices.Concat(subject.Matches, subject.Custom)) {
+				m := matchRecord{
+					Prefix:             group.Prefix,
+					Overlap:            overlapType(subject.Label, group.Prefix),
+					Region:             group.Region,
+					Services:           strings.Split(group.Services, ","),
+					NetworkBorderGroup: group.NetworkBorderGroup,
+					ZoneType:           group.ZoneType,
+					Feed:               group.Feed,
+				}
+				// The location is left out for GLOBAL and the regions
+				// that regionLocations doesn't know.
+				if loc := regionLocation(group.Region); loc != nil {
+					m.Latitude, m.Longitude, m.Country = &loc.Latitude, &loc.Longitude, loc.Country
+				}
+				if a != nil {
+					m.Tags = a.tagsForGroup(group)
+				}
+				record.Matches = append(record.Matches, m)
+			}
+			if len(record.Matches) == 0 {
+				record.Class = specialClass(subject.Label)
+			}
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// sortedRecords returns the records of the results sorted by the --sort-by
+// field of their IP or of their first match.
+func sortedRecords(results []Result, opts tableOptions) []resultRecord {
+	records := resultRecords(results, opts.Annotations)
+	if opts.SortBy == "" {
+		return records
+	}
+	value := func(r resultRecord) string {
+		if opts.SortBy == "ip" {
+			return r.IP
+		}
+		if len(r.Matches) == 0 {
+			return ""
+		}
+		switch m := r.Matches[0]; opts.SortBy {
+		case "prefix":
+			return m.Prefix
+		case "region":
+			return m.Region
+		default:
+			return strings.Join(m.Services, ",")
+		}
+	}
+	slices.SortStableFunc(records, func(a, b resultRecord) int { return opts.compare(value(a), value(b)) })
+	return records
+}
+
+// foundInAWS tells whether at least one IP has an AWS match, not counting the
+// --feed prefixes.
+func foundInAWS(results []Result) bool {
+	for _, result := range results {
+		for _, subject := range result.Subjects {
+			if len(subject.Matches) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeDelimited writes the records as CSV, or TSV when comma is a tab: a row
+// per match, or a row with empty match fields for an IP that has none or a
+// target that couldn't be looked up.
+func writeDelimited(out io.Writer, comma rune, records []resultRecord, opts tableOptions) error {
+	w := csv.NewWriter(out)
+	w.Comma = comma
+	header := []string{"target", "ip", "prefix", "region", "services", "border_group", "zone_type", "latitude", "longitude", "country", "error"}
+	if opts.Annotations != nil {
+		header = append(header, "tags")
+	}
+	if !opts.NoHeader {
+		w.Write(header)
+	}
+	var rows [][]string
+	for _, r := range records {
+		if len(r.Matches) == 0 {
+			row := []string{r.Target, r.IP, "", "", "", "", "", "", "", "", r.Error}
+			if opts.Annotations != nil {
+				row = append(row, "")
+			}
+			rows = append(rows, row)
+			continue
+		}
+		for _, m := range r.Matches {
+			var lat, long string
+			if m.Latitude != nil {
+				lat = strconv.FormatFloat(*m.Latitude, 'f', -1, 64)
+				long = strconv.FormatFloat(*m.Longitude, 'f', -1, 64)
+			}
+			row := []string{r.Target, r.IP, m.Prefix, m.Region, strings.Join(m.Services, ","), m.NetworkBorderGroup, string(m.ZoneType), lat, long, m.Country, ""}
+			if opts.Annotations != nil {
+				row = append(row, strings.TrimPrefix(formatTags(m.Tags), "-"))
+			}
+			rows = append(rows, row)
+		}
+	}
+	if opts.SortBy != "" {
+		col := 1 + slices.Index(sortKeys, opts.SortBy)
+		slices.SortStableFunc(rows, func(a, b []string) int { return opts.compare(a[col], b[col]) })
+	}
+	w.WriteAll(rows)
+	return w.Error()
+}
+
+// streamResult writes the records of a result as soon as it's known, for the
+// formats that don't need to see all the results first: ndjson writes a
+// compact JSON object per line for jq -c and log shippers, go-template its
+// lines, github the annotations, and print a line per target in AWS. The
+// other formats are left to writeResults.
+func streamResult(out, errOut io.Writer, format outputFormat, result Result, opts tableOptions) error {
+	switch format.Name {
+	case "ndjson":
+		enc := json.NewEncoder(out)
+		for _, record := range resultRecords([]Result{result}, opts.Annotations) {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+	case "go-template":
+		return executeTemplate(out, errOut, format.Template, resultRecords([]Result{result}, opts.Annotations))
+	case "github":
+		return writeGitHubAnnotations(out, result, opts.Policy)
+	case "print":
+		if result.Err != nil {
+			fmt.Fprintf(errOut, "Error: %s: %v\n", result.Target.Input, result.Err)
+			return nil
+		}
+		if value, ok := printValue(result, format.Field); ok {
+			_, err := fmt.Fprintln(out, value)
+			return err
+		}
+	}
+	return nil
+}
+
+// writeResults writes the results in the given format and returns true if at
+// least one AWS match was found. The sections added by --describe and
+// --related only exist in the table.
+func writeResults(out, errOut io.Writer, format outputFormat, results []Result, ranges *AWSIPRanges, opts tableOptions) bool {
+	switch format.Name {
+	case "ndjson", "go-template", "print":
+		// Already written by streamResult.
+		return foundInAWS(results)
+	case "github":
+		writeGitHubSummary(out, results, opts.Policy)
+		return foundInAWS(results)
+	case "junit":
+		if err := writeJUnit(out, results, opts.Policy); err != nil {
+			fmt.Fprintf(errOut, "Error: writing results: %v\n", err)
+		}
+		return foundInAWS(results)
+	case "yaml":
+		doc, err := queriedDocument(results, ranges, opts)
+		if err != nil {
+			fmt.Fprintf(errOut, "Error: --query: %v\n", err)
+			return foundInAWS(results)
+		}
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			fmt.Fprintf(errOut, "Error: writing results: %v\n", err)
+		}
+		enc.Close()
+		return foundInAWS(results)
+	case "csv", "tsv":
+		comma := ','
+		if format.Name == "tsv" {
+			comma = '\t'
+		}
+		if err := writeDelimited(out, comma, sortedRecords(results, opts), opts); err != nil {
+			fmt.Fprintf(errOut, "Error: writing results: %v\n", err)
+		}
+		return foundInAWS(results)
+	case "json":
+		doc, err := queriedDocument(results, ranges, opts)
+		if err != nil {
+			fmt.Fprintf(errOut, "Error: --query: %v\n", err)
+			return foundInAWS(results)
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			fmt.Fprintf(errOut, "Error: writing results: %v\n", err)
+		}
+		return foundInAWS(results)
+	}
+	return printResults(out, errOut, results, ranges, opts)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// hostsEntry is a line of a hosts file: an IP pinned for one or more names.
+type hostsEntry struct {
+	Line  int
+	IP    string
+	Names []string
+}
+
+// parseHostsFile reads a file in the format of /etc/hosts. Everything after
+// a '#' is a comment.
+func parseHostsFile(r io.Reader) ([]hostsEntry, error) {
+	var entries []hostsEntry
+	scanner := bufio.NewScanner(r)
+	for num := 1; scanner.Scan(); num++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: %q has no names", num, fields[0])
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", num, err)
+		}
+		entries = append(entries, hostsEntry{Line: num, IP: addr.WithZone("").String(), Names: fields[1:]})
+	}
+	return entries, scanner.Err()
+}
+
+// errStaleEntries is returned by runHosts when a pinned IP differs from what
+// DNS returns for its name.
+var errStaleEntries = errors.New("stale entries")
+
+// runHosts implements "awswhois hosts", which reports which IPs of a hosts
+// file are in AWS. Since an IP pinned in a hosts file outlives the one that
+// DNS returns, each name is also resolved, and the entries whose IP isn't one
+// of the current ones are flagged as stale, pointing out when the name has
+// moved into or out of AWS since.
+func runHosts(args []string, source rangesSource, opts lookupOptions, concurrency int, out, errOut io.Writer) error {
+	fs := flag.NewFlagSet("hosts", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] hosts [<hosts file>]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return usagef("hosts takes at most one file")
+	}
+	path := "/etc/hosts"
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	entries, err := parseHostsFile(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+
+	// The pinned IPs come first, then the names to resolve. The names of the
+	// private and reserved IPs, such as localhost, aren't in DNS.
+	var inputs []TargetLine
+	for _, entry := range entries {
+		inputs = append(inputs, TargetLine{Num: entry.Line, Text: entry.IP})
+	}
+	resolved := make(map[string]int)
+	if !opts.NoResolve {
+		for _, entry := range entries {
+			if specialClass(entry.IP) != "" {
+				continue
+			}
+			for _, name := range entry.Names {
+				if _, ok := resolved[name]; !ok {
+					resolved[name] = len(inputs)
+					inputs = append(inputs, TargetLine{Num: entry.Line, Text: name})
+				}
+			}
+		}
+	}
+	outcome := lookupAll(context.Background(), inputs, concurrency, ranges, opts, errOut)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tNAME\tIP\tPREFIX\tREGION\tSERVICE\tDNS\tSTATUS")
+	var stale int
+	for i, entry := range entries {
+		pinned := outcome(i)
+		prefix, region, service := "-", "-", "-"
+		if class := specialClass(entry.IP); class != "" {
+			prefix = class
+		}
+		if pinned.Err == nil {
+			if best, ok := printValue(pinned.Result, "prefix"); ok {
+				prefix = best
+				region, _ = printValue(pinned.Result, "region")
+				service, _ = printValue(pinned.Result, "service")
+			}
+		}
+		pinnedInAWS := region != "-"
+
+		for _, name := range entry.Names {
+			dns, status := "-", "-"
+			if j, ok := resolved[name]; ok {
+				dns, status = hostsStatus(entry.IP, pinnedInAWS, outcome(j))
+				if strings.HasPrefix(status, "stale") {
+					stale++
+				}
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.Line, name, entry.IP, prefix, region, service, dns, status)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if stale > 0 {
+		return errStaleEntries
+	}
+	return nil
+}
+
+// hostsStatus compares a pinned IP with what DNS returns for its name, and
+// returns the IPs from DNS and whether the entry is current or stale.
+func hostsStatus(ip string, pinnedInAWS bool, o lookupOutcome) (dns, status string) {
+	if o.Err != nil {
+		return "-", "not in DNS"
+	}
+	var ips []string
+	inAWS := false
+	for _, subject := range o.Result.Subjects {
+		ips = append(ips, subject.Label)
+		if len(subject.Matches) > 0 {
+			inAWS = true
+		}
+	}
+	switch {
+	case slices.Contains(ips, ip):
+		status = "current"
+	case inAWS && !pinnedInAWS:
+		status = "stale, DNS points into AWS"
+	case !inAWS && pinnedInAWS:
+		status = "stale, DNS points out of AWS"
+	default:
+		status = "stale"
+	}
+	return strings.Join(ips, ","), status
+}
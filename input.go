@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Kind tells how an input was interpreted.
+type Kind string
+
+const (
+	KindIP       Kind = "ip"
+	KindCIDR     Kind = "cidr"
+	KindRange    Kind = "range"
+	KindURL      Kind = "url"
+	KindHostname Kind = "hostname"
+	KindShortcut Kind = "shortcut"
+)
+
+// Target is an input after the decorations commonly found in logs (such as a
+// port suffix) have been stripped.
+type Target struct {
+	Input string
+	Kind  Kind
+	Host  string
+	Port  string
+
+	// Range is set when the input is an IP range or a CIDR block rather than
+	// a single host.
+	Range *IPRange
+
+	// Encoding is set to "decimal" or "hex" when the input was an IP written
+	// as a single number, and to "IPv4-mapped" when it was an IPv4 address or
+	// block written as IPv6, e.g. ::ffff:52.94.5.1. Host then holds the
+	// canonical form.
+	Encoding string
+
+	// Zone is the zone of an IPv6 address such as fe80::1%eth0, which is left
+	// out of Host since it only means something on the host that wrote it.
+	Zone string
+
+	// Unicode is set when the hostname was given with Unicode labels, e.g.
+	// bücher.example. Host then holds its punycode form, which DNS resolves.
+	Unicode bool
+}
+
+// encodingIPv4Mapped is the Encoding of the IPv4 addresses and blocks given
+// in their IPv4-mapped IPv6 form.
+const encodingIPv4Mapped = "IPv4-mapped"
+
+// parseTarget works out whether the input is an IP, a CIDR block, an IP
+// range, a URL, or a hostname. IPs and hostnames may be followed by a port as
+// found in logs and in the output of ss or netstat:
+//
+//	52.94.76.10:443
+//	[2600:1f18::1]:8443
+//	example.com:443
+//
+// Shortcuts such as @s3.eu-west-1 are expanded to the endpoint hostname.
+func parseTarget(input string) (Target, error) {
+	target := Target{Input: input, Host: input}
+
+	if strings.HasPrefix(input, "@") {
+		host, err := expandShortcut(input)
+		if err != nil {
+			return Target{}, err
+		}
+		target.Kind, target.Host = KindShortcut, host
+		return target, nil
+	}
+
+	if strings.Contains(input, "://") {
+		u, err := url.Parse(input)
+		if err != nil {
+			return Target{}, err
+		}
+		if u.Hostname() == "" {
+			return Target{}, fmt.Errorf("URL %s has no host", input)
+		}
+		target.Kind, target.Host, target.Port = KindURL, u.Hostname(), u.Port()
+		return target.toASCII()
+	}
+
+	if strings.Contains(input, "/") {
+		prefix, err := netip.ParsePrefix(input)
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid CIDR block: %w", err)
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+			target.Host, target.Encoding = prefix.String(), encodingIPv4Mapped
+		}
+		target.Kind = KindCIDR
+		target.Range = &IPRange{Start: prefix.Addr(), End: lastAddr(prefix)}
+		return target, nil
+	}
+
+	if r, ok, err := parseIPRange(input); ok {
+		if err != nil {
+			return Target{}, err
+		}
+		target.Kind, target.Range = KindRange, &r
+		return target, nil
+	}
+
+	if ip, encoding, ok := parseNumericIP(input); ok {
+		target.Kind, target.Host, target.Encoding = KindIP, ip.String(), encoding
+		return target, nil
+	}
+
+	switch {
+	case strings.HasPrefix(input, "["):
+		if strings.HasSuffix(input, "]") {
+			target.Host = strings.TrimSuffix(strings.TrimPrefix(input, "["), "]")
+			break
+		}
+		host, port, err := net.SplitHostPort(input)
+		if err != nil {
+			return Target{}, err
+		}
+		target.Host, target.Port = host, port
+	case net.ParseIP(input) != nil:
+		// A bare IPv6 address contains colons but no port.
+	case strings.Count(input, ":") == 1:
+		host, port, err := net.SplitHostPort(input)
+		if err != nil {
+			return Target{}, err
+		}
+		target.Host, target.Port = host, port
+	}
+
+	if target.Port != "" {
+		if n, err := strconv.Atoi(target.Port); err != nil || n < 1 || n > 65535 {
+			return Target{}, fmt.Errorf("invalid port %q", target.Port)
+		}
+	}
+
+	target, err := target.toASCII()
+	if err != nil {
+		return Target{}, err
+	}
+	if strings.Contains(target.Host, "%") {
+		addr, err := netip.ParseAddr(target.Host)
+		if err != nil || addr.Zone() == "" {
+			return Target{}, fmt.Errorf("invalid IPv6 address with a zone: %s", target.Host)
+		}
+		target.Host, target.Zone = addr.WithZone("").String(), addr.Zone()
+	}
+	// IPv4-mapped addresses are matched against the IPv4 prefixes.
+	if addr, err := netip.ParseAddr(target.Host); err == nil && addr.Is4In6() {
+		target.Host, target.Encoding = addr.Unmap().String(), encodingIPv4Mapped
+	}
+	switch {
+	case net.ParseIP(target.Host) != nil:
+		target.Kind = KindIP
+	case isHostname(target.Host):
+		target.Kind = KindHostname
+	default:
+		return Target{}, fmt.Errorf("not an IP, CIDR block, IP range, URL, or hostname")
+	}
+	return target, nil
+}
+
+// toASCII converts a hostname with Unicode labels to punycode, e.g.
+// bücher.example to xn--bcher-kva.example.
+func (t Target) toASCII() (Target, error) {
+	if strings.IndexFunc(t.Host, func(r rune) bool { return r >= utf8.RuneSelf }) < 0 {
+		return t, nil
+	}
+	host, err := idna.Lookup.ToASCII(t.Host)
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid internationalized hostname: %w", err)
+	}
+	t.Host, t.Unicode = host, true
+	return t, nil
+}
+
+// isHostname checks the syntax of a hostname. Underscores are tolerated since
+// they are common in practice (e.g. _dmarc records).
+func isHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TargetLine is a target read from a file or from stdin. The line number is
+// kept so that errors can point at the offending line.
+type TargetLine struct {
+	Num  int
+	Text string
+}
+
+// readTargets reads one target per line. Blank lines and everything after a
+// '#' are ignored.
+func readTargets(r io.Reader) ([]TargetLine, error) {
+	var lines []TargetLine
+	scanner := bufio.NewScanner(r)
+	for num := 1; scanner.Scan(); num++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lines = append(lines, TargetLine{Num: num, Text: line})
+	}
+	return lines, scanner.Err()
+}
+
+// uniqueTargets keeps the first line of each target.
+func uniqueTargets(lines []TargetLine) []TargetLine {
+	seen := make(map[string]bool)
+	var unique []TargetLine
+	for _, line := range lines {
+		if !seen[line.Text] {
+			seen[line.Text] = true
+			unique = append(unique, line)
+		}
+	}
+	return unique
+}
+
+// parseNumericIP decodes IPs written as a single number, as found in malware
+// configs and some old log formats: 3232235777 or 0xC0A80101 for 192.168.1.1.
+// Numbers that don't fit in 32 bits are read as IPv6 addresses.
+func parseNumericIP(input string) (ip net.IP, encoding string, ok bool) {
+	n := new(big.Int)
+	switch {
+	case strings.HasPrefix(input, "0x") || strings.HasPrefix(input, "0X"):
+		digits := input[2:]
+		if len(digits) == 0 || len(digits) > 32 {
+			return nil, "", false
+		}
+		if _, ok := n.SetString(digits, 16); !ok {
+			return nil, "", false
+		}
+		encoding = "hex"
+	case input != "" && strings.Trim(input, "0123456789") == "":
+		if _, ok := n.SetString(input, 10); !ok {
+			return nil, "", false
+		}
+		encoding = "decimal"
+	default:
+		return nil, "", false
+	}
+
+	switch {
+	case n.BitLen() <= 32:
+		ip = make(net.IP, net.IPv4len)
+		n.FillBytes(ip)
+		return ip.To16(), encoding, true
+	case n.BitLen() <= 128:
+		ip = make(net.IP, net.IPv6len)
+		n.FillBytes(ip)
+		return ip, encoding, true
+	}
+	return nil, "", false
+}
+
+// label is how an IP is shown in the output. The port of the input is kept so
+// that the line can be traced back to what was given.
+func (t Target) label(ip net.IP) string {
+	if t.Port == "" {
+		return ip.String()
+	}
+	return net.JoinHostPort(ip.String(), t.Port)
+}
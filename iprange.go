@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// IPRange is an inclusive range of addresses such as the ones found in abuse
+// reports, e.g. "192.0.2.10-192.0.2.50".
+type IPRange struct {
+	Start netip.Addr
+	End   netip.Addr
+}
+
+func (r IPRange) String() string {
+	return r.Start.String() + "-" + r.End.String()
+}
+
+// parseIPRange returns ok = false when the input doesn't look like a range,
+// which is the case of most hostnames that contain a dash.
+func parseIPRange(input string) (r IPRange, ok bool, err error) {
+	from, to, found := strings.Cut(input, "-")
+	if !found {
+		return IPRange{}, false, nil
+	}
+	start, err1 := netip.ParseAddr(strings.TrimSpace(from))
+	end, err2 := netip.ParseAddr(strings.TrimSpace(to))
+	if err1 != nil || err2 != nil {
+		return IPRange{}, false, nil
+	}
+	start, end = start.Unmap(), end.Unmap()
+
+	if start.Is4() != end.Is4() {
+		return IPRange{}, true, fmt.Errorf("range %s mixes IPv4 and IPv6", input)
+	}
+	if end.Less(start) {
+		return IPRange{}, true, fmt.Errorf("range %s ends before it starts", input)
+	}
+	return IPRange{Start: start, End: end}, true, nil
+}
+
+// CIDRs splits the range into the smallest set of CIDR blocks that cover it
+// exactly.
+func (r IPRange) CIDRs() []netip.Prefix {
+	var blocks []netip.Prefix
+	start := r.Start
+	for {
+		// Find the largest block that starts at 'start' and doesn't go past
+		// the end of the range.
+		bits := start.BitLen()
+		for bits > 0 {
+			p := netip.PrefixFrom(start, bits-1).Masked()
+			if p.Addr() != start || r.End.Less(lastAddr(p)) {
+				break
+			}
+			bits--
+		}
+		block := netip.PrefixFrom(start, bits)
+		blocks = append(blocks, block)
+
+		last := lastAddr(block)
+		if last == r.End {
+			return blocks
+		}
+		start = last.Next()
+	}
+}
+
+// Size returns the number of addresses in the range. A big.Int is needed
+// since IPv6 ranges easily go beyond 2^64.
+func (r IPRange) Size() *big.Int {
+	n := new(big.Int).Sub(addrToInt(r.End), addrToInt(r.Start))
+	return n.Add(n, big.NewInt(1))
+}
+
+// Coverage returns how many addresses of the range are covered by at least
+// one of the given prefixes. Overlapping prefixes are only counted once.
+func (r IPRange) Coverage(prefixes []netip.Prefix) *big.Int {
+	type interval struct{ lo, hi netip.Addr }
+	var intervals []interval
+	for _, p := range prefixes {
+		p = p.Masked()
+		lo, hi := p.Addr(), lastAddr(p)
+		if hi.Less(r.Start) || r.End.Less(lo) {
+			continue
+		}
+		if lo.Less(r.Start) {
+			lo = r.Start
+		}
+		if r.End.Less(hi) {
+			hi = r.End
+		}
+		intervals = append(intervals, interval{lo, hi})
+	}
+
+	// Merge the intervals once sorted so that overlaps aren't counted twice.
+	slices.SortFunc(intervals, func(a, b interval) int { return a.lo.Compare(b.lo) })
+	covered := new(big.Int)
+	var cur *interval
+	for i := range intervals {
+		iv := intervals[i]
+		if cur != nil && !cur.hi.Less(iv.lo) {
+			if cur.hi.Less(iv.hi) {
+				cur.hi = iv.hi
+			}
+			continue
+		}
+		if cur != nil {
+			covered.Add(covered, IPRange{cur.lo, cur.hi}.Size())
+		}
+		cur = &iv
+	}
+	if cur != nil {
+		covered.Add(covered, IPRange{cur.lo, cur.hi}.Size())
+	}
+	return covered
+}
+
+// lastAddr returns the broadcast address of the prefix, i.e. its last
+// address.
+func lastAddr(p netip.Prefix) netip.Addr {
+	p = p.Masked()
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+func addrToInt(addr netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(addr.AsSlice())
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// knownHost is a host of a known_hosts file, as written there, e.g.
+// example.com or [example.com]:2222.
+type knownHost struct {
+	Line    int
+	Host    string
+	KeyType string
+}
+
+// hashedHost is an entry of a known_hosts file whose host was hashed by
+// HashKnownHosts, i.e. |1|<salt>|<HMAC-SHA1 of the host>.
+type hashedHost struct {
+	Line    int
+	KeyType string
+	Salt    []byte
+	Hash    []byte
+}
+
+// matches tells whether the host is the one that was hashed.
+func (h hashedHost) matches(host string) bool {
+	mac := hmac.New(sha1.New, h.Salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), h.Hash)
+}
+
+// parseKnownHosts reads the hosts of a file in the format of
+// ~/.ssh/known_hosts. The wildcard and negated patterns can't be looked up
+// and are skipped.
+func parseKnownHosts(r io.Reader) (hosts []knownHost, hashed []hashedHost, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for num := 1; scanner.Scan(); num++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// @cert-authority and @revoked
+		if strings.HasPrefix(fields[0], "@") {
+			fields = fields[1:]
+		}
+		if len(fields) < 3 {
+			return nil, nil, fmt.Errorf("line %d: expected hosts, a key type, and a key", num)
+		}
+		patterns, keyType := fields[0], fields[1]
+
+		if salt, hash, ok := strings.Cut(strings.TrimPrefix(patterns, "|1|"), "|"); ok && strings.HasPrefix(patterns, "|1|") {
+			h := hashedHost{Line: num, KeyType: keyType}
+			if h.Salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid salt of hashed host: %w", num, err)
+			}
+			if h.Hash, err = base64.StdEncoding.DecodeString(hash); err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid hash of hashed host: %w", num, err)
+			}
+			hashed = append(hashed, h)
+			continue
+		}
+		for _, host := range strings.Split(patterns, ",") {
+			if host == "" || strings.ContainsAny(host, "*?!") {
+				continue
+			}
+			hosts = append(hosts, knownHost{Line: num, Host: host, KeyType: keyType})
+		}
+	}
+	return hosts, hashed, scanner.Err()
+}
+
+// unhash returns the hosts of the hashed entries that are among the
+// candidates, along with the number of entries left hashed. The candidates
+// are hashed as they are, so a host on another port than 22 must be given in
+// the [host]:port form, as ssh does.
+func unhash(hashed []hashedHost, candidates []TargetLine) (hosts []knownHost, remaining int) {
+	for _, h := range hashed {
+		found := false
+		for _, candidate := range candidates {
+			if h.matches(candidate.Text) {
+				hosts = append(hosts, knownHost{Line: h.Line, Host: candidate.Text, KeyType: h.KeyType})
+				found = true
+				break
+			}
+		}
+		if !found {
+			remaining++
+		}
+	}
+	return hosts, remaining
+}
+
+// runKnownHosts implements "awswhois known-hosts", which inventories the SSH
+// hosts of a known_hosts file that are in AWS. The hosts of the hashed
+// entries can't be recovered, but the ones among --candidates are found by
+// hashing them in turn.
+func runKnownHosts(args []string, source rangesSource, opts lookupOptions, concurrency int, out, errOut io.Writer) error {
+	fs := flag.NewFlagSet("known-hosts", flag.ContinueOnError)
+	candidatesFile := fs.String("candidates", "", "a file of hostnames and IPs, one per line, to try against the hashed entries")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] known-hosts [--candidates <file>] [<known_hosts>]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return usagef("known-hosts takes at most one file")
+	}
+	path := fs.Arg(0)
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	hosts, hashed, err := parseKnownHosts(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	remaining := len(hashed)
+	if *candidatesFile != "" {
+		f, err := os.Open(*candidatesFile)
+		if err != nil {
+			return err
+		}
+		candidates, err := readTargets(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", *candidatesFile, err)
+		}
+		var found []knownHost
+		found, remaining = unhash(hashed, candidates)
+		hosts = append(hosts, found...)
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	inputs := make([]TargetLine, len(hosts))
+	for i, host := range hosts {
+		inputs[i] = TargetLine{Num: host.Line, Text: host.Host}
+	}
+	outcome := lookupAll(context.Background(), inputs, concurrency, ranges, opts, errOut)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tHOST\tKEY\tIP\tPREFIX\tREGION\tSERVICE")
+	var inAWS int
+	for i, host := range hosts {
+		o := outcome(i)
+		if o.Err != nil {
+			fmt.Fprintf(w, "%d\t%s\t%s\t-\terror: %v\t\t\n", host.Line, host.Host, host.KeyType, o.Err)
+			continue
+		}
+		found := false
+		for _, subject := range o.Result.Subjects {
+			prefix, region, service, ok := auditCells(subject)
+			found = found || ok
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", host.Line, host.Host, host.KeyType, subject.Label, prefix, region, service)
+		}
+		if found {
+			inAWS++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d of %d hosts are in AWS\n", inAWS, len(hosts))
+	if remaining > 0 {
+		fmt.Fprintf(errOut, "Note: %d hashed entries were skipped; --candidates can recover their hosts\n", remaining)
+	}
+	return nil
+}
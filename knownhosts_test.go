@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// hashKnownHost hashes the host the way ssh-keygen -H does.
+func hashKnownHost(salt, host string) string {
+	mac := hmac.New(sha1.New, []byte(salt))
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString([]byte(salt)) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseKnownHosts(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		want       []knownHost
+		wantHashed int
+		wantErr    string
+	}{
+		{
+			name: "hosts and IPs",
+			file: "example.com,192.0.2.1 ssh-ed25519 AAAAC3Nza\n",
+			want: []knownHost{
+				{Line: 1, Host: "example.com", KeyType: "ssh-ed25519"},
+				{Line: 1, Host: "192.0.2.1", KeyType: "ssh-ed25519"},
+			},
+		},
+		{
+			name: "non-standard port",
+			file: "[example.com]:2222 ssh-rsa AAAAB3Nza\n",
+			want: []knownHost{
+				{Line: 1, Host: "[example.com]:2222", KeyType: "ssh-rsa"},
+			},
+		},
+		{
+			name: "comments and blank lines",
+			file: "# bastions\n\n   \nbastion.example.com ecdsa-sha2-nistp256 AAAAE2Vj # prod\n",
+			want: []knownHost{
+				{Line: 4, Host: "bastion.example.com", KeyType: "ecdsa-sha2-nistp256"},
+			},
+		},
+		{
+			name: "markers",
+			file: "@cert-authority *.example.com ssh-rsa AAAAB3Nza\n@revoked old.example.com ssh-rsa AAAAB3Nza\n",
+			want: []knownHost{
+				{Line: 2, Host: "old.example.com", KeyType: "ssh-rsa"},
+			},
+		},
+		{
+			name: "wildcards and negations",
+			file: "*.example.com,!bad.example.com,host?.example.com,good.example.com ssh-ed25519 AAAAC3Nza\n",
+			want: []knownHost{
+				{Line: 1, Host: "good.example.com", KeyType: "ssh-ed25519"},
+			},
+		},
+		{
+			name:       "hashed entries",
+			file:       hashKnownHost("salt-one-20-bytes!!!", "example.com") + " ssh-ed25519 AAAAC3Nza\nplain.example.com ssh-rsa AAAAB3Nza\n",
+			want:       []knownHost{{Line: 2, Host: "plain.example.com", KeyType: "ssh-rsa"}},
+			wantHashed: 1,
+		},
+		{
+			name:    "missing key",
+			file:    "example.com ssh-rsa\n",
+			wantErr: "line 1: expected hosts, a key type, and a key",
+		},
+		{
+			name:    "invalid salt",
+			file:    "|1|not#base64|AAAA ssh-rsa AAAAB3Nza\n",
+			wantErr: "line 1: invalid salt of hashed host",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, hashed, err := parseKnownHosts(strings.NewReader(tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(hosts, tt.want) {
+				t.Errorf("got hosts %+v, want %+v", hosts, tt.want)
+			}
+			if len(hashed) != tt.wantHashed {
+				t.Errorf("got %d hashed entries, want %d", len(hashed), tt.wantHashed)
+			}
+		})
+	}
+}
+
+func TestUnhash(t *testing.T) {
+	file := strings.Join([]string{
+		hashKnownHost("salt-one-20-bytes!!!", "example.com") + " ssh-ed25519 AAAAC3Nza",
+		hashKnownHost("salt-two-20-bytes!!!", "[example.com]:2222") + " ssh-rsa AAAAB3Nza",
+		hashKnownHost("salt-three-20-bytes!", "unknown.example.com") + " ssh-rsa AAAAB3Nza",
+	}, "\n")
+	_, hashed, err := parseKnownHosts(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		candidates    []string
+		want          []knownHost
+		wantRemaining int
+	}{
+		{
+			name:          "no candidates",
+			wantRemaining: 3,
+		},
+		{
+			name:       "port 22 and another port",
+			candidates: []string{"other.example.com", "example.com", "[example.com]:2222"},
+			want: []knownHost{
+				{Line: 1, Host: "example.com", KeyType: "ssh-ed25519"},
+				{Line: 2, Host: "[example.com]:2222", KeyType: "ssh-rsa"},
+			},
+			wantRemaining: 1,
+		},
+		{
+			name:          "another port needs the bracketed form",
+			candidates:    []string{"example.com:2222"},
+			want:          nil,
+			wantRemaining: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var candidates []TargetLine
+			for i, c := range tt.candidates {
+				candidates = append(candidates, TargetLine{Num: i + 1, Text: c})
+			}
+			hosts, remaining := unhash(hashed, candidates)
+			if !slices.Equal(hosts, tt.want) {
+				t.Errorf("got hosts %+v, want %+v", hosts, tt.want)
+			}
+			if remaining != tt.wantRemaining {
+				t.Errorf("got %d remaining, want %d", remaining, tt.wantRemaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// familyPrefixes returns the prefixes of the family given with --family,
+// which is ipv4, ipv6, or all, once the lookup filters are applied.
+func familyPrefixes(ranges *AWSIPRanges, family string, opts lookupOptions) ([]AWSMatch, error) {
+	var matches []AWSMatch
+	switch family {
+	case "ipv4":
+		matches = allPrefixes(ranges, true)
+	case "ipv6":
+		matches = allPrefixes(ranges, false)
+	case "all":
+		matches = slices.Concat(allPrefixes(ranges, true), allPrefixes(ranges, false))
+	default:
+		return nil, usagef("unknown family %q, must be one of: ipv4, ipv6, all", family)
+	}
+	return opts.filter(matches), nil
+}
+
+// runList implements "awswhois list", which prints the AWS prefixes that the
+// --service, --region, and --zone-type flags select.
+func runList(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	family := fs.String("family", "all", "only list the prefixes of this address family (ipv4, ipv6, all)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] list [--family ipv4|ipv6|all]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return usagef("list takes no arguments")
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	matches, err := familyPrefixes(ranges, *family, opts)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tREGION\tSERVICE\tBORDER GROUP\tZONE TYPE")
+	for _, group := range groupMatches(matches) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", group.Prefix, group.Region, group.Services, group.NetworkBorderGroup, zoneLabel(group))
+	}
+	return w.Flush()
+}
+
+// familyStats counts the prefixes of one address family and the space they
+// cover. IPv4 space is counted in addresses and IPv6 space in /64 subnets,
+// the usual size of a VPC subnet. Nested prefixes are counted once.
+type familyStats struct {
+	Prefixes int
+
+	blocks []netip.Prefix
+}
+
+func (s *familyStats) add(p netip.Prefix) {
+	s.Prefixes++
+	s.blocks = append(s.blocks, p)
+}
+
+// space returns the space covered by the blocks once the ones covered by a
+// larger block are dropped.
+func (s *familyStats) space() uint64 {
+	slices.SortFunc(s.blocks, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+	var total uint64
+	var last netip.Prefix
+	for _, p := range s.blocks {
+		if last.IsValid() && last.Bits() <= p.Bits() && last.Contains(p.Addr()) {
+			continue
+		}
+		last = p
+		if p.Addr().Is4() {
+			total += 1 << (32 - p.Bits())
+		} else if p.Bits() <= 64 {
+			total += 1 << (64 - p.Bits())
+		} else {
+			total++
+		}
+	}
+	return total
+}
+
+// runStats implements "awswhois stats", which counts the prefixes and the
+// address space per region or per service, and reports the ones that have
+// IPv4 prefixes but no IPv6 ones yet.
+func runStats(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	family := fs.String("family", "all", "only count the prefixes of this address family (ipv4, ipv6, all)")
+	by := fs.String("by", "region", "count per region or per service")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] stats [--by region|service] [--family ipv4|ipv6|all]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return usagef("stats takes no arguments")
+	}
+	var key func(AWSMatch) string
+	switch *by {
+	case "region":
+		key = func(m AWSMatch) string { return m.Region }
+	case "service":
+		key = func(m AWSMatch) string { return m.Service }
+	default:
+		return usagef("unknown --by %q, must be region or service", *by)
+	}
+	if !slices.Contains([]string{"ipv4", "ipv6", "all"}, *family) {
+		return usagef("unknown family %q, must be one of: ipv4, ipv6, all", *family)
+	}
+	showV4, showV6 := *family != "ipv6", *family != "ipv4"
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	// Both families are always counted so that the missing IPv6 coverage
+	// can be told even when only IPv6 is shown.
+	matches, err := familyPrefixes(ranges, "all", opts)
+	if err != nil {
+		return err
+	}
+
+	type row struct{ v4, v6 familyStats }
+	rows := make(map[string]*row)
+	var total row
+	for _, m := range matches {
+		p, err := netip.ParsePrefix(m.Prefix)
+		if err != nil {
+			continue
+		}
+		r := rows[key(m)]
+		if r == nil {
+			r = &row{}
+			rows[key(m)] = r
+		}
+		if p.Addr().Is4() {
+			r.v4.add(p)
+			total.v4.add(p)
+		} else {
+			r.v6.add(p)
+			total.v6.add(p)
+		}
+	}
+
+	columns := func(r *row) string {
+		var cols []string
+		if showV4 {
+			cols = append(cols, fmt.Sprint(r.v4.Prefixes), fmt.Sprint(r.v4.space()))
+		}
+		if showV6 {
+			cols = append(cols, fmt.Sprint(r.v6.Prefixes), fmt.Sprint(r.v6.space()))
+		}
+		return strings.Join(cols, "\t")
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := []string{strings.ToUpper(*by)}
+	if showV4 {
+		header = append(header, "IPV4 PREFIXES", "IPV4 ADDRESSES")
+	}
+	if showV6 {
+		header = append(header, "IPV6 PREFIXES", "IPV6 /64S")
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	var lacking []string
+	for _, k := range slices.Sorted(maps.Keys(rows)) {
+		r := rows[k]
+		if r.v4.Prefixes > 0 && r.v6.Prefixes == 0 {
+			lacking = append(lacking, k)
+		}
+		if !showV4 && r.v6.Prefixes == 0 || !showV6 && r.v4.Prefixes == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\n", k, columns(r))
+	}
+	fmt.Fprintf(w, "TOTAL\t%s\n", columns(&total))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if showV6 && len(lacking) > 0 {
+		fmt.Fprintf(out, "\n%d %ss have IPv4 prefixes but no IPv6 ones: %s\n", len(lacking), *by, strings.Join(lacking, ", "))
+	}
+	return nil
+}
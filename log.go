@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"time"
+)
+
+// setupLogger configures the default slog logger. The CLI only logs warnings
+// and errors by default; the long-running modes (--watch-file) are meant to
+// be run with --log-format json so that log collectors can parse them.
+func setupLogger(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q, must be one of: debug, info, warn, error", level)
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+			Level: lvl,
+			// The time is noise for a CLI that runs for a second.
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey && len(groups) == 0 {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: lvl})
+	default:
+		return fmt.Errorf("invalid log format %q, must be one of: text, json", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// withHTTPTrace logs the steps of the HTTP requests made with ctx at the
+// debug level: the DNS answer, the connection, the TLS handshake, and the
+// first byte of the response. It tells where a request that timed out got
+// stuck.
+func withHTTPTrace(ctx context.Context) context.Context {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return ctx
+	}
+	start := time.Now()
+	since := func() string { return time.Since(start).Round(time.Millisecond).String() }
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			slog.Debug("http: resolved", "addrs", info.Addrs, "err", info.Err, "after", since())
+		},
+		ConnectDone: func(network, addr string, err error) {
+			slog.Debug("http: connected", "addr", addr, "err", err, "after", since())
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			slog.Debug("http: TLS handshake done", "version", tls.VersionName(state.Version), "err", err, "after", since())
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				slog.Debug("http: reusing connection", "addr", info.Conn.RemoteAddr())
+			}
+		},
+		GotFirstResponseByte: func() {
+			slog.Debug("http: got the first byte of the response", "after", since())
+		},
+	})
+}
+
+// fatal logs the error and returns exitFailure, for run to return so that
+// the deferred shutdowns still flush the telemetry.
+func fatal(msg string, args ...any) int {
+	slog.Error(msg, args...)
+	return exitFailure
+}
+
+// fatalUsage is fatal for a command line that can't be run, such as an
+// invalid flag value or a file given by a flag that can't be read.
+func fatalUsage(msg string, args ...any) int {
+	slog.Error(msg, args...)
+	return exitUsage
+}
+
+// usageError is an error of the command line, which exits with exitUsage.
+// Reported is set when the flag package already printed it along with the
+// usage.
+type usageError struct {
+	err      error
+	reported bool
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// usagef returns a usageError with the message formatted as with
+// fmt.Errorf.
+func usagef(format string, args ...any) error {
+	return usageError{err: fmt.Errorf(format, args...)}
+}
+
+// parseFlags parses the flags of a subcommand, whose FlagSet must be created
+// with flag.ContinueOnError. The error is flag.ErrHelp for -h and a
+// usageError otherwise.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return usageError{err: err, reported: true}
+	}
+	return nil
+}
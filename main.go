@@ -1,22 +1,48 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
-	"text/tabwriter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmespath/go-jmespath"
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/term"
 )
 
 const awsIPRangesURL = "https://ip-ranges.amazonaws.com/ip-ranges.json"
 
 type AWSIPRanges struct {
-	SyncToken  string       `json:"syncToken"`
-	CreateDate string       `json:"createDate"`
-	Prefixes   []IPPrefix   `json:"prefixes"`
+	SyncToken    string       `json:"syncToken"`
+	CreateDate   string       `json:"createDate"`
+	Prefixes     []IPPrefix   `json:"prefixes"`
 	IPv6Prefixes []IPv6Prefix `json:"ipv6_prefixes"`
+
+	// Custom holds the prefixes of the feeds given with --feed. They aren't
+	// part of ip-ranges.json but are matched alongside it.
+	Custom []CustomPrefix `json:"-"`
+
+	// FetchedAt is when the ranges were downloaded, and FromCache tells
+	// whether they were read from --cache-dir rather than downloaded.
+	// Embedded is set when they are the snapshot built into the binary.
+	FetchedAt time.Time `json:"-"`
+	FromCache bool      `json:"-"`
+	Embedded  bool      `json:"-"`
 }
 
 type IPPrefix struct {
@@ -34,106 +60,1117 @@ type IPv6Prefix struct {
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <ip-or-hostname>\n", os.Args[0])
-		os.Exit(1)
+	os.Exit(run())
+}
+
+// run runs the command line and returns the exit code, rather than exiting
+// itself, so that the deferred shutdowns flush the telemetry and the metrics
+// on every path.
+func run() int {
+	var output string
+	flag.StringVar(&output, "output", "table", "format of the results: "+strings.Join(outputFormats, ", ")+", go-template=<template>, or go-template-file=<file>")
+	flag.StringVar(&output, "o", "table", "shorthand for --output")
+	var quiet bool
+	flag.BoolVar(&quiet, "quiet", false, "print nothing and only report through the exit status whether a target is in AWS")
+	flag.BoolVar(&quiet, "q", false, "shorthand for --quiet")
+	noHeader := flag.Bool("no-header", false, "leave out the header row of the table, or of the csv and tsv outputs")
+	colorFlag := flag.String("color", "auto", "color the table: auto (when stdout is a terminal and NO_COLOR isn't set), always, never")
+	metadata := flag.Bool("metadata", false, "with the json, ndjson, and yaml outputs, also write the syncToken and createDate of the AWS IP ranges, when they were fetched, and whether they came from the cache")
+	query := flag.String("query", "", "a JMESPath expression applied to the json or yaml output, as with the AWS CLI, e.g. '[].matches[].region'; implies --output json")
+	printFlag := flag.String("print", "", "only print the "+strings.Join(printFields, ", ")+" of the most specific match of each target, e.g. for REGION=$(awswhois --print region <ip>)")
+	bestMatch := flag.Bool("best-match", false, "only show the most specific prefix of each IP, i.e. a single row")
+	sortBy := flag.String("sort-by", "", "sort the results by "+strings.Join(sortKeys, ", ")+" (default: the order of the targets)")
+	reverse := flag.Bool("reverse", false, "reverse the order given by --sort-by")
+	columnsFlag := flag.String("columns", "", "comma-separated columns of the table, e.g. ip,region,service (default: all but ptr and tags, which needs --annotations)")
+	wide := flag.Bool("wide", false, "add the PTR column, the reverse DNS name of each IP, to the table")
+	narrow := flag.Bool("narrow", false, "only show the IP, prefix, region, and service columns, with the services collapsed (default when the table is wider than the terminal)")
+	describe := flag.Bool("describe", false, "describe each matched service and link to its documentation")
+	related := flag.Bool("related", false, "list the other AWS prefixes that are supernets or subnets of each matched prefix")
+	excludeWavelength := flag.Bool("exclude-wavelength", false, "ignore prefixes that belong to Wavelength Zones (carrier 5G networks)")
+	serviceFlag := flag.String("service", "", "only show prefixes of these comma-separated services; the names are matched loosely, e.g. 'cloud front'")
+	regionFlag := flag.String("region", "", "only show prefixes of these comma-separated regions, given as codes or city names, e.g. eu-central-1 or frankfurt")
+	zoneTypeFlag := flag.String("zone-type", "", "only show prefixes of this zone type (region, local-zone, wavelength-zone, global, custom)")
+	targetsFile := flag.String("file", "", "check the targets listed in this file, one per line; blank lines and # comments are ignored")
+	execCmd := flag.String("exec", "", "run this shell command and check every IP found in its output, e.g. 'ss -tn'")
+	watchFile := flag.String("watch-file", "", "keep checking the targets listed in this file and report when their classification changes")
+	pagerDutyKey := flag.String("pagerduty-routing-key", "", "with --watch-file, open PagerDuty incidents using this Events API v2 routing key")
+	opsgenieKey := flag.String("opsgenie-api-key", "", "with --watch-file, open Opsgenie alerts using this API key")
+	teamsWebhook := flag.String("teams-webhook", "", "with --watch-file, post a summary of the changes to this Microsoft Teams incoming webhook URL")
+	snsTopic := flag.String("sns-topic-arn", "", "with --watch-file, publish a JSON event for each change to this SNS topic")
+	sqsQueue := flag.String("sqs-queue-url", "", "with --watch-file, send a JSON event for each change to this SQS queue")
+	eventBus := flag.String("eventbridge-bus", "", "with --watch-file, put an event for each change on this EventBridge bus (name or ARN)")
+	statsdAddr := flag.String("statsd", "", "send metrics to this StatsD or DogStatsD server (host:port)")
+	statsdTags := flag.String("statsd-tags", "", "comma-separated DogStatsD tags added to every metric, e.g. env:prod,team:netops")
+	var feeds []feed
+	flag.Func("feed", "also match the labelled CIDRs listed in this JSON or CSV file or URL, given as name=path-or-url; can be repeated", func(s string) error {
+		f, err := parseFeed(s)
+		if err != nil {
+			return err
+		}
+		feeds = append(feeds, f)
+		return nil
+	})
+	flag.Func("extra-ranges", "also match the CIDRs of this YAML file, which maps each CIDR to a label such as corp-vpn; can be repeated", func(s string) error {
+		feeds = append(feeds, extraRangesFeed(s))
+		return nil
+	})
+	excludeFile := flag.String("exclude-prefixes", "", "ignore the prefixes listed in this file, one CIDR per line, e.g. noisy aggregates or ranges handled elsewhere")
+	annotationsFile := flag.String("annotations", "", "YAML file tagging prefixes or region/service pairs with free-form tags (owner, ticket...), shown in a TAGS column")
+	var tagFilters []tagFilter
+	flag.Func("tag", "with --annotations, only show the matches with this tag, given as key=value or key; can be repeated", func(s string) error {
+		tagFilters = append(tagFilters, parseTagFilter(s))
+		return nil
+	})
+	failIfAWS := flag.Bool("fail-if-aws", false, "exit with status 1 if any IP is in AWS, e.g. to keep egress targets off AWS")
+	failIfNotAWS := flag.Bool("fail-if-not-aws", false, "exit with status 1 unless every IP is in AWS, e.g. to check that a dependency is hosted on AWS")
+	expectFlag := flag.String("expect", "", "fail unless every IP has a match with all these values, e.g. region=eu-west-1,service=S3 (keys: service, region, zone-type, border-group, prefix)")
+	checkpointFile := flag.String("checkpoint", "", "with a batch, append the outcome of each target to this file as soon as it is known, so that a crash doesn't lose the lookups already done")
+	resume := flag.Bool("resume", false, "with --checkpoint, skip the targets already recorded in the checkpoint file and only look up and print the missing ones")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "send traces and metrics over OTLP/HTTP to this collector, e.g. http://localhost:4318 (default: the OTEL_EXPORTER_OTLP_ENDPOINT environment variable, if set)")
+	alertRegions := flag.String("alert-allowed-regions", "", "with --watch-file, comma-separated regions the targets may live in; incidents are opened when a target leaves AWS or these regions (default: alert on any change)")
+	noProgress := flag.Bool("no-progress", false, "don't show a progress bar on stderr while a batch is looked up, which is only shown when stderr is a terminal")
+	unique := flag.Bool("unique", false, "only report the first occurrence of a target repeated in a batch; repeated targets are only looked up once either way")
+	concurrency := flag.Int("concurrency", 1, "look up to this many targets of a batch at a time; the results keep the order of the targets")
+	noResolve := flag.Bool("no-resolve", false, "never use DNS: the targets that aren't IPs, CIDR blocks, or IP ranges are errors, e.g. for deterministic CI runs")
+	ipv4Only := flag.Bool("ipv4-only", false, "only resolve the A records of hostnames")
+	ipv6Only := flag.Bool("ipv6-only", false, "only resolve the AAAA records of hostnames")
+	resolveTimeout := flag.Duration("resolve-timeout", 5*time.Second, "give up resolving a hostname after this long; in a batch, the target is reported as an error and the others carry on")
+	interval := flag.Duration("interval", 5*time.Minute, "with --watch-file, how often to refresh the AWS IP ranges and re-check the targets; with serve, how often to refresh the ranges")
+	listen := flag.String("listen", "localhost:8080", "with serve, the address to listen on")
+	schedule := flag.String("schedule", "", "with --watch-file, refresh the AWS IP ranges and re-check the targets on this cron schedule instead of every --interval, e.g. '0 * * * *'")
+	diffRanges := flag.Bool("diff-ranges", false, "with --watch-file, also print the prefixes added and removed by each refresh of the AWS IP ranges")
+	exportSpec := flag.String("export", "", "with --watch-file and --export-file, the format and flags of an export rewritten on each refresh of the AWS IP ranges, e.g. 'cidr --service S3'")
+	exportFile := flag.String("export-file", "", "with --watch-file and --export, the file that the export is written to")
+	cacheDir := flag.String("cache-dir", defaultCacheDir(), "keep a copy of ip-ranges.json in this directory, e.g. a volume shared by several hosts; 'fetch' refreshes it")
+	cacheTTL := flag.Duration("cache-ttl", time.Hour, "use the copy of ip-ranges.json in --cache-dir until it is this old before downloading it again; 0 downloads it every time")
+	fetchTimeout := flag.Duration("timeout", 30*time.Second, "give up each attempt to download the AWS IP ranges after this long")
+	fetchRetries := flag.Int("retries", 2, "retry a failed download of the AWS IP ranges this many times, with a jittered exponential backoff")
+	rangesURL := flag.String("ranges-url", awsIPRangesURL, "download the AWS IP ranges from this URL, e.g. an internal mirror of ip-ranges.json")
+	rangesFile := flag.String("ranges-file", "", "load the AWS IP ranges from this copy of ip-ranges.json instead of downloading them, e.g. to pin the lookups to a known version; '-' reads it from stdin")
+	offline := flag.Bool("offline", false, "use the snapshot of the AWS IP ranges embedded in the binary; never use the network or the cache")
+	cacheReadOnly := flag.Bool("cache-read-only", false, "load the AWS IP ranges from --cache-dir only; never use the network or write anything there")
+	logLevel := flag.String("log-level", "warn", "only log messages at or above this level (debug, info, warn, error)")
+	var debug bool
+	flag.BoolVar(&debug, "debug", false, "log the download of the AWS IP ranges, the use of the cache, and the DNS lookups; same as --log-level debug")
+	flag.BoolVar(&debug, "v", false, "shorthand for --debug")
+	logFormat := flag.String("log-format", "text", "format of the logs written to stderr (text, json)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [lookup] <ip|cidr|range|url|hostname>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] - < targets.txt\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] --file <targets.txt>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] --exec <command>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] watch <file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] repl\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] tui\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] assert --require <rule> --forbid <rule> <target>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] list|ranges [--family ipv4|ipv6|all]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] stats [--by region|service] [--family ipv4|ipv6|all]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] export <format> [format flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] annotate --field <name> [--format csv|tsv|jsonl] < records\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] zone [--origin <domain>] <zone file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] hosts [<hosts file>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] known-hosts [--candidates <file>] [<known_hosts>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] log [--format nginx|apache|combined|common] [--per-ip] [<access.log>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] pcap <capture.pcap>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] diff <old.json> [<new.json>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [flags] serve\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s --cache-dir <dir> fetch\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s validate [--previous <file>] <ranges.json>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s completion bash|zsh|fish\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	// A bad flag exits with exitUsage rather than the 2 of flag.ExitOnError,
+	// which is the one of a target that couldn't be looked up.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		return commandExit("", usageError{err: err, reported: true})
 	}
 
-	input := os.Args[1]
+	// "lookup" and "watch" are the subcommand names of the default mode and
+	// of --watch-file. The flags may follow them, so the rest of the command
+	// line is parsed again.
+	if flag.NArg() >= 1 && (flag.Arg(0) == "lookup" || flag.Arg(0) == "watch") {
+		name := flag.Arg(0)
+		if err := flag.CommandLine.Parse(flag.Args()[1:]); err != nil {
+			return commandExit(name, usageError{err: err, reported: true})
+		}
+		if name == "watch" {
+			if flag.NArg() != 1 {
+				flag.Usage()
+				return exitUsage
+			}
+			*watchFile = flag.Arg(0)
+		}
+	}
 
-	// Fetch AWS IP ranges
-	ranges, err := fetchAWSIPRanges()
+	if debug {
+		*logLevel = "debug"
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	lookupOpts := lookupOptions{
+		ExcludeWavelength: *excludeWavelength,
+		ResolveTimeout:    *resolveTimeout,
+		BestMatch:         *bestMatch,
+		NoResolve:         *noResolve,
+	}
+	switch {
+	case *ipv4Only && *ipv6Only:
+		return fatalUsage("--ipv4-only and --ipv6-only can't be used together")
+	case *ipv4Only:
+		lookupOpts.Network = "ip4"
+	case *ipv6Only:
+		lookupOpts.Network = "ip6"
+	}
+	if *annotationsFile != "" {
+		var err error
+		lookupOpts.Annotations, err = readAnnotations(*annotationsFile)
+		if err != nil {
+			return fatalUsage("reading --annotations", "err", err)
+		}
+	}
+	if len(tagFilters) > 0 && *annotationsFile == "" {
+		return fatalUsage("--tag requires --annotations")
+	}
+	format, err := parseOutput(output)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error fetching AWS IP ranges: %v\n", err)
-		os.Exit(1)
+		return fatalUsage("invalid --output", "err", err)
+	}
+	var queryExpr *jmespath.JMESPath
+	if *query != "" {
+		if queryExpr, err = jmespath.Compile(*query); err != nil {
+			return fatalUsage("invalid --query", "err", err)
+		}
+		switch {
+		case output == "table":
+			format = outputFormat{Name: "json"}
+		case format.Name != "json" && format.Name != "yaml":
+			return fatalUsage("--query requires --output json or yaml")
+		}
+	}
+	if *printFlag != "" {
+		if !slices.Contains(printFields, *printFlag) {
+			return fatalUsage("invalid --print", "err", fmt.Errorf("unknown field %q, must be one of: %s", *printFlag, strings.Join(printFields, ", ")))
+		}
+		if output != "table" {
+			return fatalUsage("--print can't be used with --output")
+		}
+		format = outputFormat{Name: "print", Field: *printFlag}
+		lookupOpts.BestMatch = true
+	}
+	color, err := useColor(*colorFlag, os.Stdout)
+	if err != nil {
+		return fatalUsage("invalid --color", "err", err)
+	}
+	var columns []string
+	if *columnsFlag != "" {
+		if columns, err = parseColumns(*columnsFlag); err != nil {
+			return fatalUsage("invalid --columns", "err", err)
+		}
+		if slices.Contains(columns, "tags") && *annotationsFile == "" {
+			return fatalUsage("the tags column requires --annotations")
+		}
+	}
+	var layout string
+	switch {
+	case *wide && *narrow:
+		return fatalUsage("--wide and --narrow can't be used together")
+	case *wide:
+		layout = layoutWide
+	case *narrow:
+		layout = layoutNarrow
+	}
+	if *noResolve && (*wide || slices.Contains(columns, "ptr")) {
+		return fatalUsage("--no-resolve can't be used with the ptr column, which needs DNS")
+	}
+	var width int
+	if fd := int(os.Stdout.Fd()); term.IsTerminal(fd) {
+		width, _, _ = term.GetSize(fd)
+	}
+	if *sortBy != "" && !slices.Contains(sortKeys, *sortBy) {
+		return fatalUsage("invalid --sort-by", "err", fmt.Errorf("unknown key %q, must be one of: %s", *sortBy, strings.Join(sortKeys, ", ")))
+	}
+	if *sortBy != "" && (format.streamed()) {
+		return fatalUsage("--sort-by can't be used with streamed outputs", "output", output)
+	}
+	if *metadata && !format.isJSON() && format.Name != "yaml" {
+		return fatalUsage("--metadata requires --output json, ndjson, or yaml")
+	}
+	if *reverse && *sortBy == "" {
+		return fatalUsage("--reverse requires --sort-by")
+	}
+	lookupOpts.Tags = tagFilters
+	lookupOpts.Services = splitList(*serviceFlag)
+	lookupOpts.Regions = splitList(*regionFlag)
+	if *zoneTypeFlag != "" {
+		var err error
+		lookupOpts.ZoneType, err = parseZoneType(*zoneTypeFlag)
+		if err != nil {
+			return fatalUsage("invalid --zone-type", "err", err)
+		}
 	}
 
-	// Resolve input to IPs
-	ips, err := resolveToIPs(input)
+	shutdownTelemetry, err := setupTelemetry(context.Background(), *otlpEndpoint)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error resolving %s: %v\n", input, err)
-		os.Exit(1)
+		return fatalUsage("setting up OpenTelemetry", "err", err)
 	}
+	defer shutdownTelemetry()
 
-	if len(ips) == 0 {
-		fmt.Fprintf(os.Stderr, "No IP addresses found for %s\n", input)
-		os.Exit(1)
+	source := rangesSource{CacheDir: *cacheDir, CacheTTL: *cacheTTL, CacheReadOnly: *cacheReadOnly, Offline: *offline, RangesFile: *rangesFile, URL: *rangesURL, Timeout: *fetchTimeout, Retries: *fetchRetries, Feeds: feeds}
+	if *excludeFile != "" {
+		var err error
+		source.ExcludePrefixes, err = readPrefixesFile(*excludeFile)
+		if err != nil {
+			return fatalUsage("reading --exclude-prefixes", "err", err)
+		}
+	}
+	if source.CacheReadOnly && source.CacheDir == "" {
+		return fatalUsage("--cache-read-only requires --cache-dir")
+	}
+	if source.Timeout <= 0 || source.Retries < 0 {
+		return fatalUsage("--timeout must be positive and --retries can't be negative")
+	}
+	if u, err := url.Parse(source.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fatalUsage("--ranges-url must be an http or https URL", "url", source.URL)
+	}
+	if source.RangesFile != "" && (source.Offline || source.CacheReadOnly) {
+		return fatalUsage("--ranges-file can't be used with --offline or --cache-read-only")
+	}
+	if source.RangesFile == "-" {
+		if slices.Contains(flag.Args(), "-") || slices.Contains([]string{"annotate", "repl", "tui"}, flag.Arg(0)) {
+			return fatalUsage("--ranges-file - can't be used with annotate, repl, tui, or targets read from stdin, which read stdin too")
+		}
+		var err error
+		if source.RangesBody, err = io.ReadAll(os.Stdin); err != nil {
+			return fatal("reading the AWS IP ranges from stdin", "err", err)
+		}
 	}
 
-	// Check each IP against AWS ranges and collect results
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "IP\tPREFIX\tREGION\tSERVICE\tBORDER GROUP")
+	if flag.NArg() >= 1 && flag.Arg(0) == "completion" {
+		return commandExit("completion", runCompletion(flag.Args()[1:], os.Stdout))
+	}
 
-	found := false
-	for _, ip := range ips {
-		matches := findAWSMatches(ip, ranges)
-		if len(matches) > 0 {
-			found = true
-			// Group matches by IP + Prefix + Region + NetworkBorderGroup
-			grouped := groupMatches(matches)
-			for _, group := range grouped {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
-					ip.String(),
-					group.Prefix,
-					group.Region,
-					group.Services,
-					group.NetworkBorderGroup)
+	if flag.NArg() >= 1 && flag.Arg(0) == "__complete" {
+		return commandExit("__complete", runComplete(flag.Args()[1:], source, os.Stdout))
+	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "validate" {
+		err := runValidate(flag.Args()[1:], os.Stdout)
+		if errors.Is(err, errInvalidRanges) {
+			return exitCheckFailed
+		}
+		return commandExit("validate", err)
+	}
+
+	if flag.NArg() == 1 && flag.Arg(0) == "fetch" {
+		if source.CacheDir == "" || source.CacheReadOnly || source.Offline || source.RangesFile != "" {
+			return fatalUsage("fetch requires --cache-dir and can't be used with --cache-read-only, --offline, or --ranges-file")
+		}
+		ranges, err := source.fetch()
+		if err != nil {
+			slog.Error("fetching AWS IP ranges", "err", err)
+			return exitRangesFailure
+		}
+		fmt.Printf("Saved %d IPv4 and %d IPv6 prefixes (%s) to %s\n",
+			len(ranges.Prefixes), len(ranges.IPv6Prefixes), ranges.CreateDate, source.cachePath())
+		return 0
+	}
+
+	var stats *statsdClient
+	if *statsdAddr != "" {
+		var err error
+		stats, err = newStatsdClient(*statsdAddr, splitList(*statsdTags))
+		if err != nil {
+			return fatal("connecting to StatsD", "addr", *statsdAddr, "err", err)
+		}
+		defer stats.Close()
+	}
+
+	if *watchFile == "" && (*schedule != "" || *diffRanges || *exportSpec != "" || *exportFile != "") {
+		return fatalUsage("--schedule, --diff-ranges, --export, and --export-file require --watch-file")
+	}
+	if *watchFile != "" {
+		var sinks []changeSink
+		var alerters []alerter
+		if *pagerDutyKey != "" {
+			alerters = append(alerters, &pagerDuty{routingKey: *pagerDutyKey})
+		}
+		if *opsgenieKey != "" {
+			alerters = append(alerters, &opsgenie{apiKey: *opsgenieKey})
+		}
+		if len(alerters) > 0 {
+			sinks = append(sinks, newAlertManager(alerters, splitList(*alertRegions)))
+		}
+		if *teamsWebhook != "" {
+			sinks = append(sinks, &teamsNotifier{webhookURL: *teamsWebhook})
+		}
+		if *snsTopic != "" {
+			publisher, err := newSNSPublisher(context.Background(), *snsTopic)
+			if err != nil {
+				return fatal("setting up SNS", "err", err)
 			}
-		} else {
-			fmt.Fprintf(w, "%s\t-\t-\t-\t-\n", ip.String())
+			sinks = append(sinks, publisher)
 		}
+		if *sqsQueue != "" {
+			publisher, err := newSQSPublisher(context.Background(), *sqsQueue)
+			if err != nil {
+				return fatal("setting up SQS", "err", err)
+			}
+			sinks = append(sinks, publisher)
+		}
+		if *eventBus != "" {
+			publisher, err := newEventBridgePublisher(context.Background(), *eventBus)
+			if err != nil {
+				return fatal("setting up EventBridge", "err", err)
+			}
+			sinks = append(sinks, publisher)
+		}
+
+		// The ranges are refreshed every --interval, which a copy cached for
+		// longer mustn't hold back.
+		source.CacheTTL = min(source.CacheTTL, *interval)
+		wt := newWatcher(*watchFile, *interval, source, lookupOpts)
+		if *schedule != "" {
+			var err error
+			wt.schedule, err = cron.ParseStandard(*schedule)
+			if err != nil {
+				return fatalUsage("invalid --schedule", "err", err)
+			}
+		}
+		if (*exportSpec == "") != (*exportFile == "") {
+			return fatalUsage("--export and --export-file go together")
+		}
+		wt.exportArgs, wt.exportFile = strings.Fields(*exportSpec), *exportFile
+		wt.diffRanges = *diffRanges
+		wt.sinks = sinks
+		wt.stats = stats
+		return commandExit("watching targets", wt.run())
+	}
+
+	tableOpts := tableOptions{
+		Describe:    *describe,
+		Related:     *related,
+		Columns:     columns,
+		NoHeader:    *noHeader,
+		Color:       color,
+		Annotations: lookupOpts.Annotations,
+		SortBy:      *sortBy,
+		Reverse:     *reverse,
+		Layout:      layout,
+		Width:       width,
+		Metadata:    *metadata,
+		Query:       queryExpr,
+	}
+
+	if *concurrency < 1 {
+		return fatalUsage("--concurrency must be at least 1")
+	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "assert" {
+		err := runAssert(flag.Args()[1:], source, lookupOpts, os.Stdout)
+		if errors.Is(err, errViolations) {
+			return exitCheckFailed
+		}
+		return commandExit("assert", err)
+	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "export" {
+		return commandExit("export", runExport(flag.Args()[1:], source, lookupOpts, os.Stdout))
+	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "annotate" {
+		return commandExit("annotate", runAnnotate(flag.Args()[1:], source, lookupOpts, os.Stdin, os.Stdout))
+	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "zone" {
+		return commandExit("zone", runZone(flag.Args()[1:], source, lookupOpts, *concurrency, os.Stdout, os.Stderr))
+	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "hosts" {
+		err := runHosts(flag.Args()[1:], source, lookupOpts, *concurrency, os.Stdout, os.Stderr)
+		if errors.Is(err, errStaleEntries) {
+			return exitCheckFailed
+		}
+		return commandExit("hosts", err)
 	}
-	w.Flush()
 
-	if !found {
-		os.Exit(1)
+	if flag.NArg() >= 1 && flag.Arg(0) == "known-hosts" {
+		return commandExit("known-hosts", runKnownHosts(flag.Args()[1:], source, lookupOpts, *concurrency, os.Stdout, os.Stderr))
 	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "log" {
+		return commandExit("log", runAccessLog(flag.Args()[1:], source, lookupOpts, *concurrency, os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "pcap" {
+		return commandExit("pcap", runPcap(flag.Args()[1:], source, lookupOpts, *concurrency, os.Stdout, os.Stderr))
+	}
+
+	if flag.NArg() >= 1 && flag.Arg(0) == "diff" {
+		return commandExit("diff", runDiff(flag.Args()[1:], source, lookupOpts, os.Stdout))
+	}
+
+	if flag.NArg() >= 1 && (flag.Arg(0) == "list" || flag.Arg(0) == "ranges" || flag.Arg(0) == "stats") {
+		list := runList
+		if flag.Arg(0) == "stats" {
+			list = runStats
+		}
+		return commandExit(flag.Arg(0), list(flag.Args()[1:], source, lookupOpts, os.Stdout))
+	}
+
+	if flag.NArg() == 1 && flag.Arg(0) == "serve" {
+		source.CacheTTL = min(source.CacheTTL, *interval)
+		srv := newServer(source, *interval, lookupOpts)
+		srv.stats = stats
+		return commandExit("serving", srv.run(*listen))
+	}
+
+	if flag.NArg() == 1 && flag.Arg(0) == "repl" {
+		return commandExit("repl", runREPL(source, lookupOpts, tableOpts))
+	}
+
+	if flag.NArg() == 1 && flag.Arg(0) == "tui" {
+		return commandExit("tui", runTUI(source, lookupOpts))
+	}
+
+	if *failIfAWS && *failIfNotAWS {
+		return fatalUsage("--fail-if-aws and --fail-if-not-aws can't be used together")
+	}
+
+	var expect []rule
+	if *expectFlag != "" {
+		var err error
+		expect, err = parseExpect(*expectFlag)
+		if err != nil {
+			return fatalUsage("invalid --expect", "err", err)
+		}
+	}
+	tableOpts.Policy = policy{Expect: expect, FailIfAWS: *failIfAWS, FailIfNotAWS: *failIfNotAWS}
+
+	if *targetsFile != "" && (*execCmd != "" || flag.NArg() > 0) {
+		return fatalUsage("--file can't be used with --exec or with targets given as arguments")
+	}
+
+	var inputs []TargetLine
+	switch {
+	case *targetsFile != "":
+		f, err := os.Open(*targetsFile)
+		if err != nil {
+			return fatalUsage("reading targets", "err", err)
+		}
+		inputs, err = readTargets(f)
+		f.Close()
+		if err != nil {
+			return fatalUsage("reading targets", "file", *targetsFile, "err", err)
+		}
+	case *execCmd != "":
+		ips, err := extractIPsFromCommand(*execCmd)
+		if err != nil {
+			return fatal("running command", "command", *execCmd, "err", err)
+		}
+		if len(ips) == 0 {
+			return fatal("no IP addresses found in the output of the command", "command", *execCmd)
+		}
+		for _, ip := range ips {
+			inputs = append(inputs, TargetLine{Text: ip})
+		}
+	case flag.Arg(0) == "-" && flag.NArg() == 1:
+		// Read a batch of targets, one per line, from stdin.
+		lines, err := readTargets(os.Stdin)
+		if err != nil {
+			return fatal("reading targets from stdin", "err", err)
+		}
+		inputs = lines
+	case flag.NArg() >= 1:
+		for _, arg := range flag.Args() {
+			if arg == "-" {
+				return fatalUsage("- reads the targets from stdin and can't be given along with other targets")
+			}
+			inputs = append(inputs, TargetLine{Text: arg})
+		}
+	default:
+		flag.Usage()
+		return exitUsage
+	}
+
+	if *unique {
+		inputs = uniqueTargets(inputs)
+	}
+
+	var cp *checkpoint
+	if *checkpointFile != "" {
+		if *resume {
+			done, err := readCheckpoint(*checkpointFile)
+			if err != nil {
+				return fatal("reading checkpoint", "file", *checkpointFile, "err", err)
+			}
+			var missing []TargetLine
+			for _, input := range inputs {
+				if !done[input.Text] {
+					missing = append(missing, input)
+				}
+			}
+			if skipped := len(inputs) - len(missing); skipped > 0 {
+				fmt.Fprintf(os.Stderr, "Note: skipping %d targets already in %s\n", skipped, *checkpointFile)
+			}
+			if len(missing) == 0 {
+				return 0
+			}
+			inputs = missing
+		}
+		cp, err = openCheckpoint(*checkpointFile, *resume)
+		if err != nil {
+			return fatal("opening checkpoint", "file", *checkpointFile, "err", err)
+		}
+		defer cp.Close()
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		switch {
+		case format.isJSON():
+			writeErrorRecord(os.Stderr, errorRecord{Code: errCodeFetch, Message: err.Error()})
+		case format.Name == "github":
+			fmt.Printf("::error title=awswhois::%s\n", githubEscaper.Replace("loading AWS IP ranges: "+err.Error()))
+		case format.Name == "nagios":
+			fmt.Printf("AWSWHOIS UNKNOWN - loading AWS IP ranges: %v\n", err)
+			return nagiosUnknown
+		default:
+			slog.Error("loading AWS IP ranges", "err", err)
+		}
+		return exitRangesFailure
+	}
+	lookupOpts, err = lookupOpts.resolveNames(ranges)
+	if err != nil {
+		return fatalUsage("invalid filter", "err", err)
+	}
+
+	// With --quiet, only the exit status tells the outcome.
+	out, errOut := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if quiet {
+		out, errOut = io.Discard, io.Discard
+	}
+
+	if err := streamMetadata(out, format, ranges, tableOpts); err != nil {
+		return fatal("writing results", "err", err)
+	}
+
+	// In a batch, a line or an argument that can't be parsed or resolved is
+	// reported as an error row rather than failing the whole batch. So is a
+	// single target with the JSON and CI outputs, which report errors in-band.
+	var bar *progress
+	if len(inputs) > 1 && !quiet && !*noProgress && term.IsTerminal(int(os.Stderr.Fd())) {
+		bar = newProgress(os.Stderr, len(inputs))
+	}
+
+	var results []Result
+	outcome := lookupAll(context.Background(), inputs, *concurrency, ranges, lookupOpts, errOut)
+	for i, input := range inputs {
+		o := outcome(i)
+		result, err := o.Result, o.Err
+		if err != nil && input.Num > 0 {
+			result = Result{
+				Target: Target{Input: input.Text},
+				Err:    fmt.Errorf("line %d: %w", input.Num, err),
+			}
+		} else if err != nil && (len(inputs) > 1 || format.inBandErrors()) {
+			result = Result{Target: Target{Input: input.Text}, Err: err}
+		} else if err != nil {
+			slog.Error("lookup failed", "err", err)
+			return exitLookupFailure
+		}
+		if cp != nil {
+			if err := cp.Record(input.Num, result); err != nil {
+				return fatal("writing checkpoint", "file", *checkpointFile, "err", err)
+			}
+		}
+		if bar != nil && format.streamed() {
+			bar.clear()
+		}
+		if err := streamResult(out, errOut, format, result, tableOpts); err != nil {
+			return fatal("writing results", "err", err)
+		}
+		results = append(results, result)
+		if bar != nil {
+			bar.add(result)
+		}
+	}
+	if bar != nil {
+		bar.clear()
+	}
+
+	stats.RecordResults(results)
+	stats.RecordDataAge(ranges)
+
+	if format.Name == "nagios" {
+		// A monitoring check has exit codes of its own.
+		return writeNagios(out, results, tableOpts.Policy)
+	}
+	found := writeResults(out, errOut, format, results, ranges, tableOpts)
+
+	if expect != nil && !checkExpect(errOut, results, expect) {
+		return exitCheckFailed
+	}
+	code := lookupExitCode(results, found)
+	if *failIfAWS || *failIfNotAWS {
+		// The gate replaces the "is any target in AWS" question, but a
+		// target that couldn't be checked still fails it.
+		code = exitFound
+		if !checkAWSGate(errOut, results, *failIfNotAWS) {
+			code = exitCheckFailed
+		} else if slices.ContainsFunc(results, func(r Result) bool { return r.Err != nil }) {
+			code = exitLookupFailure
+		}
+	}
+	return code
+}
+
+// The exit codes of a lookup, so that scripts can tell a target outside AWS
+// from a target that couldn't be checked, a run that failed, or a command
+// line that can't be run.
+const (
+	exitFound         = 0  // at least one target is in AWS
+	exitNotFound      = 1  // the targets were looked up and none is in AWS
+	exitCheckFailed   = 1  // a check found problems: --expect, assert, validate, hosts
+	exitLookupFailure = 2  // none is in AWS and some couldn't be parsed or resolved
+	exitRangesFailure = 3  // the AWS IP ranges couldn't be downloaded or parsed
+	exitFailure       = 4  // any other error, e.g. the results couldn't be written
+	exitUsage         = 64 // invalid flags or arguments, as EX_USAGE of sysexits.h
+)
+
+// commandExit logs the error of a subcommand, if any, and returns the exit
+// code it maps to.
+func commandExit(name string, err error) int {
+	var usage usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usage):
+		if !usage.reported {
+			slog.Error(name, "err", err)
+		}
+		return exitUsage
+	case errors.As(err, new(rangesError)):
+		slog.Error(name, "err", err)
+		return exitRangesFailure
+	}
+	slog.Error(name, "err", err)
+	return exitFailure
+}
+
+// lookupExitCode returns the exit code of a lookup. A match wins over the
+// errors of the other targets of a batch.
+func lookupExitCode(results []Result, found bool) int {
+	if found {
+		return exitFound
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			return exitLookupFailure
+		}
+	}
+	return exitNotFound
 }
 
-func fetchAWSIPRanges() (*AWSIPRanges, error) {
-	resp, err := http.Get(awsIPRangesURL)
+// errNotModified is returned by fetchAWSIPRanges when ip-ranges.json hasn't
+// changed since the download that the validators come from.
+var errNotModified = errors.New("not modified")
+
+// httpStatusError is returned by fetchAWSIPRanges when the server answers
+// with another status than 200 or 304.
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Status)
+}
+
+// fetchAWSIPRanges downloads ip-ranges.json from url, giving up when ctx is
+// done. The raw document is returned along with the parsed ranges and its
+// validators so that it can be cached as is. With the validators of a
+// previous download, the request is conditional and errNotModified is
+// returned when the document is the same.
+func fetchAWSIPRanges(ctx context.Context, url string, since cacheValidators) (ranges *AWSIPRanges, body []byte, validators cacheValidators, err error) {
+	ctx, span := tracer.Start(ctx, "fetch ip-ranges.json",
+		trace.WithAttributes(attribute.String("url.full", url)))
+	defer func(start time.Time) {
+		fetchDuration.Record(ctx, time.Since(start).Seconds())
+		endSpan(span, err)
+	}(time.Now())
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(withHTTPTrace(ctx), http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, validators, err
+	}
+	if since.ETag != "" {
+		req.Header.Set("If-None-Match", since.ETag)
+	}
+	if since.LastModified != "" {
+		req.Header.Set("If-Modified-Since", since.LastModified)
+	}
+	slog.Debug("downloading AWS IP ranges", "url", url, "etag", since.ETag, "lastModified", since.LastModified)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Debug("downloading AWS IP ranges failed", "url", url, "err", err, "duration", time.Since(start))
+		return nil, nil, validators, err
 	}
 	defer resp.Body.Close()
+	slog.Debug("got a response", "url", url, "status", resp.StatusCode,
+		"contentLength", resp.ContentLength, "duration", time.Since(start))
 
+	if resp.StatusCode == http.StatusNotModified && (since.ETag != "" || since.LastModified != "") {
+		return nil, nil, since, errNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		return nil, nil, validators, &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, validators, err
 	}
 
-	var ranges AWSIPRanges
-	if err := json.Unmarshal(body, &ranges); err != nil {
-		return nil, err
+	ranges = &AWSIPRanges{FetchedAt: time.Now().UTC()}
+	if err := json.Unmarshal(body, ranges); err != nil {
+		return nil, nil, validators, err
 	}
+	validators = cacheValidators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	slog.Debug("fetched AWS IP ranges", "url", url, "bytes", len(body),
+		"duration", time.Since(start), "syncToken", ranges.SyncToken, "createDate", ranges.CreateDate)
+	span.SetAttributes(attribute.String("awswhois.sync_token", ranges.SyncToken),
+		attribute.Int("awswhois.prefixes", len(ranges.Prefixes)+len(ranges.IPv6Prefixes)))
 
-	return &ranges, nil
+	return ranges, body, validators, nil
 }
 
-func resolveToIPs(input string) ([]net.IP, error) {
+func resolveToIPs(ctx context.Context, input, network string, timeout time.Duration) (ips []net.IP, err error) {
 	// Try parsing as IP first
 	if ip := net.ParseIP(input); ip != nil {
 		return []net.IP{ip}, nil
 	}
 
 	// Otherwise, resolve as hostname
-	ips, err := net.LookupIP(input)
+	ctx, span := tracer.Start(ctx, "resolve", trace.WithAttributes(attribute.String("dns.question.name", input)))
+	defer func(start time.Time) {
+		resolveDuration.Record(ctx, time.Since(start).Seconds())
+		span.SetAttributes(attribute.Int("awswhois.ips", len(ips)))
+		endSpan(span, err)
+	}(time.Now())
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	if network == "" {
+		network = "ip"
+	}
+	ips, err = net.DefaultResolver.LookupIP(ctx, network, input)
+	if ctx.Err() == context.DeadlineExceeded {
+		slog.Debug("DNS lookup timed out", "host", input, "timeout", timeout)
+		return nil, fmt.Errorf("lookup %s: %w after %s", input, errResolveTimeout, timeout)
+	}
 	if err != nil {
+		slog.Debug("DNS lookup failed", "host", input, "err", err, "duration", time.Since(start))
 		return nil, err
 	}
+	slog.Debug("DNS lookup", "host", input, "ips", ips, "duration", time.Since(start))
 
 	return ips, nil
 }
 
+// splitList splits a comma-separated flag value, ignoring empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Result holds what was found for one of the inputs. In a batch, a target
+// that couldn't be parsed or resolved has Err set instead of subjects.
+type Result struct {
+	Target   Target
+	Subjects []Subject
+	Err      error
+}
+
+// Subject is something the AWS prefixes are matched against: an IP address,
+// or one of the CIDR blocks an IP range was split into.
+type Subject struct {
+	Label   string
+	Matches []AWSMatch
+
+	// Custom holds the matches against the --feed prefixes, kept apart so
+	// that they never count as being in AWS.
+	Custom []AWSMatch
+}
+
+// lookupOptions holds the flags that change how targets are resolved and
+// which matches are kept.
+type lookupOptions struct {
+	ZoneType          ZoneType
+	ExcludeWavelength bool
+
+	// ResolveTimeout bounds the DNS resolution of each target so that one
+	// unresponsive name doesn't hold up a whole batch.
+	ResolveTimeout time.Duration
+
+	// NoResolve refuses the targets that would need DNS, so that only
+	// literal IPs, CIDR blocks, and IP ranges are looked up.
+	NoResolve bool
+
+	// Network restricts the resolution of hostnames to their A records with
+	// "ip4" or to their AAAA records with "ip6". Both are looked up when
+	// empty.
+	Network string
+
+	// Only the matches with all of Tags, as given by Annotations, are kept.
+	Annotations annotations
+	Tags        []tagFilter
+
+	// Only the matches of these services and regions are kept. They are
+	// given loosely by the user and must go through resolveNames.
+	Services []string
+	Regions  []string
+
+	// BestMatch only keeps the most specific prefix of each IP.
+	BestMatch bool
+}
+
+// resolveNames replaces the services and regions given by the user, such as
+// "cloud front" or "frankfurt", by the names found in the ranges.
+func (o lookupOptions) resolveNames(ranges *AWSIPRanges) (lookupOptions, error) {
+	services, regions := make([]string, len(o.Services)), make([]string, len(o.Regions))
+	for i, svc := range o.Services {
+		var err error
+		if services[i], err = resolveService(svc, ranges); err != nil {
+			return o, usageError{err: err}
+		}
+	}
+	for i, region := range o.Regions {
+		var err error
+		if regions[i], err = resolveRegion(region, ranges); err != nil {
+			return o, usageError{err: err}
+		}
+	}
+	o.Services, o.Regions = services, regions
+	return o, nil
+}
+
+func (o lookupOptions) filter(matches []AWSMatch) []AWSMatch {
+	if o.ZoneType != "" {
+		matches = filterByZoneType(matches, o.ZoneType)
+	}
+	if o.ExcludeWavelength {
+		matches = excludeZoneType(matches, ZoneTypeWavelength)
+	}
+	if len(o.Tags) > 0 {
+		matches = filterByTags(matches, o.Annotations, o.Tags)
+	}
+	if len(o.Services) > 0 || len(o.Regions) > 0 {
+		matches = slices.DeleteFunc(matches, func(m AWSMatch) bool {
+			return len(o.Services) > 0 && !slices.Contains(o.Services, m.Service) ||
+				len(o.Regions) > 0 && !slices.Contains(o.Regions, m.Region)
+		})
+	}
+	return matches
+}
+
+// longestPrefixMatches keeps the matches of the most specific prefix, e.g.
+// the EC2 /29 rather than the AMAZON /13 it is carved out of. The services
+// sharing that prefix are all kept, and end up in a single row.
+func longestPrefixMatches(matches []AWSMatch) []AWSMatch {
+	longest := -1
+	for _, m := range matches {
+		if p, err := netip.ParsePrefix(m.Prefix); err == nil && p.Bits() > longest {
+			longest = p.Bits()
+		}
+	}
+	return slices.DeleteFunc(matches, func(m AWSMatch) bool {
+		p, err := netip.ParsePrefix(m.Prefix)
+		return err != nil || p.Bits() != longest
+	})
+}
+
+// sortMatches orders the matches from the least to the most specific
+// prefix, and then by address, so that repeated runs print the same output
+// whatever the order of ip-ranges.json.
+func sortMatches(matches []AWSMatch) []AWSMatch {
+	slices.SortStableFunc(matches, func(a, b AWSMatch) int {
+		pa, errA := netip.ParsePrefix(a.Prefix)
+		pb, errB := netip.ParsePrefix(b.Prefix)
+		if errA != nil || errB != nil {
+			return strings.Compare(a.Prefix, b.Prefix)
+		}
+		if c := pa.Bits() - pb.Bits(); c != 0 {
+			return c
+		}
+		return pa.Addr().Compare(pb.Addr())
+	})
+	return matches
+}
+
+// lookup resolves the target and matches each of its IPs, or each CIDR block
+// when the target is an IP range, against the AWS prefixes.
+func lookup(ctx context.Context, target Target, ranges *AWSIPRanges, opts lookupOptions) (result Result, err error) {
+	ctx, span := tracer.Start(ctx, "lookup", trace.WithAttributes(
+		attribute.String("awswhois.target", target.Input),
+		attribute.String("awswhois.kind", string(target.Kind))))
+	defer func(start time.Time) {
+		recordLookup(ctx, start, result, err)
+		span.SetAttributes(attribute.Int("awswhois.matched_prefixes", len(result.matchedPrefixes())))
+		endSpan(span, err)
+	}(time.Now())
+
+	result = Result{Target: target}
+
+	if target.Range != nil {
+		_, match := tracer.Start(ctx, "match")
+		defer match.End()
+		for _, block := range target.Range.CIDRs() {
+			result.Subjects = append(result.Subjects, Subject{
+				Label:   block.String(),
+				Matches: sortMatches(opts.filter(findAWSOverlaps(block, ranges))),
+				Custom:  sortMatches(opts.filter(findCustomMatches(block, ranges))),
+			})
+		}
+		return result, nil
+	}
+
+	ips, err := resolveToIPs(ctx, target.Host, opts.Network, opts.ResolveTimeout)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(ips) == 0 {
+		return Result{}, fmt.Errorf("no IP addresses found")
+	}
+
+	// The resolver may return the IPs in any order, e.g. round-robin.
+	slices.SortFunc(ips, func(a, b net.IP) int { return bytes.Compare(a.To16(), b.To16()) })
+
+	_, match := tracer.Start(ctx, "match")
+	defer match.End()
+	for _, ip := range ips {
+		subject := Subject{
+			Label:   target.label(ip),
+			Matches: sortMatches(opts.filter(findAWSMatches(ip, ranges))),
+		}
+		if opts.BestMatch {
+			subject.Matches = longestPrefixMatches(subject.Matches)
+		}
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			addr = addr.Unmap()
+			subject.Custom = sortMatches(opts.filter(findCustomMatches(netip.PrefixFrom(addr, addr.BitLen()), ranges)))
+		}
+		result.Subjects = append(result.Subjects, subject)
+	}
+	return result, nil
+}
+
+// lookupInput parses the input and looks it up. The canonical form of inputs
+// given as a number and the hostname behind shortcuts are printed so that the
+// user can check the conversion.
+func lookupInput(ctx context.Context, input string, ranges *AWSIPRanges, opts lookupOptions, errOut io.Writer) (Result, error) {
+	target, err := parseTarget(input)
+	if err != nil {
+		return Result{}, &lookupError{Code: errCodeParse, Input: input, Err: err}
+	}
+	if opts.NoResolve && target.Range == nil && net.ParseIP(target.Host) == nil {
+		return Result{}, &lookupError{Code: errCodeParse, Input: input, Err: errNoResolve}
+	}
+	if target.Encoding != "" {
+		fmt.Fprintf(errOut, "Note: %s is the %s form of %s\n", input, target.Encoding, target.Host)
+	}
+	if target.Host != input && strings.HasPrefix(input, "@") || target.Unicode {
+		fmt.Fprintf(errOut, "Note: %s is %s\n", input, target.Host)
+	}
+
+	result, err := lookup(ctx, target, ranges, opts)
+	if err != nil {
+		code := errCodeResolve
+		if errors.Is(err, errResolveTimeout) {
+			code = errCodeTimeout
+		}
+		return Result{}, &lookupError{Code: code, Input: input, Err: err}
+	}
+	return result, nil
+}
+
+// The codes of the errors in the machine-readable outputs.
+const (
+	errCodeParse   = "parse"   // the input isn't a target
+	errCodeResolve = "resolve" // the hostname couldn't be resolved
+	errCodeTimeout = "timeout" // the hostname took longer than --resolve-timeout
+	errCodeFetch   = "fetch"   // the AWS IP ranges couldn't be loaded
+)
+
+// errResolveTimeout is returned when a hostname takes longer than
+// --resolve-timeout to resolve.
+var errResolveTimeout = errors.New("timed out")
+
+// errNoResolve is returned with --no-resolve for the targets that need DNS.
+var errNoResolve = errors.New("not an IP, CIDR block, or IP range, and --no-resolve forbids DNS lookups")
+
+// lookupError is the error of a target that couldn't be looked up. Code tells
+// why, so that the machine-readable outputs can report it.
+type lookupError struct {
+	Code  string
+	Input string
+	Err   error
+}
+
+func (e *lookupError) Error() string {
+	if e.Code == errCodeParse {
+		return fmt.Sprintf("parsing %s: %v", e.Input, e.Err)
+	}
+	return fmt.Sprintf("resolving %s: %v", e.Input, e.Err)
+}
+
+func (e *lookupError) Unwrap() error { return e.Err }
+
+// errorCode returns the code of a lookup error, or an empty string for the
+// other errors.
+func errorCode(err error) string {
+	var lerr *lookupError
+	if errors.As(err, &lerr) {
+		return lerr.Code
+	}
+	return ""
+}
+
+// matchedPrefixes returns the distinct prefixes matched by the result.
+func (r Result) matchedPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, subject := range r.Subjects {
+		for _, match := range subject.Matches {
+			p, err := netip.ParsePrefix(match.Prefix)
+			if err == nil && !slices.Contains(prefixes, p) {
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+	return prefixes
+}
+
 type AWSMatch struct {
 	Prefix             string
 	Region             string
 	Service            string
 	NetworkBorderGroup string
+
+	// Feed is the name of the --feed the prefix comes from, and is empty for
+	// the AWS prefixes.
+	Feed string
 }
 
 type GroupedMatch struct {
@@ -141,6 +1178,8 @@ type GroupedMatch struct {
 	Region             string
 	Services           string
 	NetworkBorderGroup string
+	ZoneType           ZoneType
+	Feed               string
 }
 
 func findAWSMatches(ip net.IP, ranges *AWSIPRanges) []AWSMatch {
@@ -183,12 +1222,57 @@ func findAWSMatches(ip net.IP, ranges *AWSIPRanges) []AWSMatch {
 	return matches
 }
 
+// findAWSOverlaps returns the AWS prefixes that overlap the given block,
+// whether they contain it or are contained in it.
+func findAWSOverlaps(block netip.Prefix, ranges *AWSIPRanges) []AWSMatch {
+	var matches []AWSMatch
+	for _, match := range allPrefixes(ranges, block.Addr().Is4()) {
+		prefix, err := netip.ParsePrefix(match.Prefix)
+		if err != nil {
+			continue
+		}
+		if prefix.Overlaps(block) {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// The ways an AWS prefix can overlap a CIDR block. Two CIDR blocks never
+// overlap partially: either they are the same or one contains the other.
+const (
+	overlapExact    = "exact"
+	overlapSupernet = "supernet" // the AWS prefix contains the block
+	overlapSubnet   = "subnet"   // the block contains the AWS prefix
+)
+
+// overlapType tells how the prefix overlaps the block given as input, or
+// returns an empty string when the label isn't a CIDR block, e.g. an IP.
+func overlapType(label, prefix string) string {
+	block, err := netip.ParsePrefix(label)
+	if err != nil {
+		return ""
+	}
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case p.Bits() == block.Bits():
+		return overlapExact
+	case p.Bits() < block.Bits():
+		return overlapSupernet
+	}
+	return overlapSubnet
+}
+
 func groupMatches(matches []AWSMatch) []GroupedMatch {
 	// Group by Prefix + Region + NetworkBorderGroup
 	type groupKey struct {
 		Prefix             string
 		Region             string
 		NetworkBorderGroup string
+		Feed               string
 	}
 
 	grouped := make(map[groupKey][]string)
@@ -199,6 +1283,7 @@ func groupMatches(matches []AWSMatch) []GroupedMatch {
 			Prefix:             match.Prefix,
 			Region:             match.Region,
 			NetworkBorderGroup: match.NetworkBorderGroup,
+			Feed:               match.Feed,
 		}
 
 		if _, exists := grouped[key]; !exists {
@@ -209,22 +1294,22 @@ func groupMatches(matches []AWSMatch) []GroupedMatch {
 		grouped[key] = append(grouped[key], match.Service)
 	}
 
-	// Convert to GroupedMatch slice
+	// Convert to GroupedMatch slice. The services are sorted and
+	// deduplicated so that the rows don't depend on the order of
+	// ip-ranges.json.
 	var result []GroupedMatch
 	for _, key := range keys {
-		services := ""
-		for i, svc := range grouped[key] {
-			if i > 0 {
-				services += ","
-			}
-			services += svc
-		}
+		services := grouped[key]
+		slices.Sort(services)
+		services = slices.Compact(services)
 
 		result = append(result, GroupedMatch{
 			Prefix:             key.Prefix,
 			Region:             key.Region,
-			Services:           services,
+			Services:           strings.Join(services, ","),
 			NetworkBorderGroup: key.NetworkBorderGroup,
+			ZoneType:           matchZoneType(AWSMatch{Region: key.Region, NetworkBorderGroup: key.NetworkBorderGroup, Feed: key.Feed}),
+			Feed:               key.Feed,
 		})
 	}
 
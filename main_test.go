@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestRunExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "invalid flag", args: []string{"--no-such-flag"}, want: exitUsage},
+		{name: "watch flag without --watch-file", args: []string{"--diff-ranges", "3.4.12.4"}, want: exitUsage},
+		{name: "command fails", args: []string{"--exec", "exit 3"}, want: exitFailure},
+		{name: "command prints no IP", args: []string{"--exec", "echo none"}, want: exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, commandLine := os.Args, flag.CommandLine
+			defer func() { os.Args, flag.CommandLine = args, commandLine }()
+			os.Args = append([]string{"awswhois", "--cache-dir", t.TempDir(), "--ranges-url", "http://127.0.0.1:1/ip-ranges.json", "--retries", "0"}, tt.args...)
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+			flag.CommandLine.SetOutput(io.Discard)
+
+			if got := run(); got != tt.want {
+				t.Errorf("run() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
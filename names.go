@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// normalizeName folds the case and drops spaces, dashes and underscores so
+// that "cloud front" and "Cloud_Front" both give "CLOUDFRONT".
+func normalizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
+
+// resolveService returns the service token of the live data that the user
+// meant, e.g. CLOUDFRONT for "cloud front".
+func resolveService(input string, ranges *AWSIPRanges) (string, error) {
+	services := distinctValues(ranges, func(m AWSMatch) string { return m.Service })
+	for _, svc := range services {
+		if normalizeName(svc) == normalizeName(input) {
+			return svc, nil
+		}
+	}
+	return "", unknownNameError("service", input, services, nil)
+}
+
+// resolveRegion returns the region code that the user meant, either as a code
+// in any case ("EU-West-1") or as the city the region is named after
+// ("frankfurt").
+func resolveRegion(input string, ranges *AWSIPRanges) (string, error) {
+	regions := distinctValues(ranges, func(m AWSMatch) string { return m.Region })
+	for _, region := range regions {
+		if normalizeName(region) == normalizeName(input) {
+			return region, nil
+		}
+	}
+
+	var byCity []string
+	for _, region := range regions {
+		if loc := regionLocation(region); loc != nil && strings.Contains(normalizeName(loc.City), normalizeName(input)) {
+			byCity = append(byCity, region)
+		}
+	}
+	if len(byCity) == 1 {
+		return byCity[0], nil
+	}
+	return "", unknownNameError("region", input, regions, byCity)
+}
+
+// unknownNameError lists the closest candidates in a "did you mean" error.
+// When some are already known to be close, e.g. several regions in the same
+// city, they are suggested instead.
+func unknownNameError(kind, input string, candidates, close []string) error {
+	if len(close) == 0 {
+		maxDistance := max(2, len(input)/3)
+		type scored struct {
+			name     string
+			distance int
+		}
+		var scores []scored
+		for _, c := range candidates {
+			d := levenshtein(normalizeName(input), normalizeName(c))
+			if strings.Contains(normalizeName(c), normalizeName(input)) {
+				d = 1
+			}
+			if d <= maxDistance {
+				scores = append(scores, scored{c, d})
+			}
+		}
+		slices.SortStableFunc(scores, func(a, b scored) int { return a.distance - b.distance })
+		for i, s := range scores {
+			if i == 3 {
+				break
+			}
+			close = append(close, s.name)
+		}
+	}
+
+	if len(close) == 0 {
+		return fmt.Errorf("unknown %s %q", kind, input)
+	}
+	return fmt.Errorf("unknown %s %q, did you mean %s?", kind, input, strings.Join(close, " or "))
+}
+
+// distinctValues returns the distinct values of a field of the prefixes,
+// sorted.
+func distinctValues(ranges *AWSIPRanges, field func(AWSMatch) string) []string {
+	seen := make(map[string]bool)
+	var values []string
+	for _, ipv4 := range []bool{true, false} {
+		for _, m := range allPrefixes(ranges, ipv4) {
+			if v := field(m); !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	slices.Sort(values)
+	return values
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// teamsNotifier posts a summary of the changes to a Microsoft Teams incoming
+// webhook. The message uses the MessageCard format, which both the legacy
+// Office 365 connectors and the Workflows webhooks accept.
+type teamsNotifier struct {
+	webhookURL string
+}
+
+func (t *teamsNotifier) HandleChanges(ctx context.Context, changes []Change) error {
+	changes = actualChanges(changes)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var facts []map[string]string
+	for _, change := range changes {
+		facts = append(facts, map[string]string{
+			"name":  change.Target,
+			"value": fmt.Sprintf("%s → %s", change.Before.Summary, change.After.Summary),
+		})
+	}
+
+	return postJSON(ctx, t.webhookURL, nil, map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    changeTitle(changes),
+		"themeColor": "FF9900",
+		"title":      changeTitle(changes),
+		"sections":   []map[string]any{{"facts": facts}},
+	})
+}
+
+// changeTitle returns a one-line summary of the changes, e.g. "awswhois: 2
+// targets changed".
+func changeTitle(changes []Change) string {
+	if len(changes) == 1 {
+		return fmt.Sprintf("awswhois: %s changed", changes[0].Target)
+	}
+	var targets []string
+	for _, change := range changes {
+		targets = append(targets, change.Target)
+	}
+	return fmt.Sprintf("awswhois: %d targets changed (%s)", len(changes), strings.Join(targets, ", "))
+}
+
+// actualChanges leaves out the targets seen for the first time, which are
+// reported when the watch starts or when a target is added to the file.
+func actualChanges(changes []Change) []Change {
+	var actual []Change
+	for _, change := range changes {
+		if change.Before != nil {
+			actual = append(actual, change)
+		}
+	}
+	return actual
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/maelvls/awswhois"
+
+// The tracer and meter go through the global providers, which do nothing
+// until setupTelemetry installs the OTLP ones.
+var (
+	tracer = otel.Tracer(instrumentationName)
+	meter  = otel.Meter(instrumentationName)
+
+	lookupCounter, _ = meter.Int64Counter("awswhois.lookups",
+		metric.WithDescription("Targets looked up, by outcome (matched, unmatched, error)"))
+	lookupDuration, _ = meter.Float64Histogram("awswhois.lookup.duration",
+		metric.WithDescription("Time taken to resolve and match a target"), metric.WithUnit("s"))
+	resolveDuration, _ = meter.Float64Histogram("awswhois.resolve.duration",
+		metric.WithDescription("Time taken by the DNS resolution of a hostname"), metric.WithUnit("s"))
+	fetchDuration, _ = meter.Float64Histogram("awswhois.fetch.duration",
+		metric.WithDescription("Time taken to download and parse ip-ranges.json"), metric.WithUnit("s"))
+)
+
+// setupTelemetry sends traces and metrics over OTLP/HTTP to the given
+// endpoint, e.g. http://localhost:4318. When the endpoint is empty, the
+// standard OTEL_EXPORTER_OTLP_* environment variables are used instead, and
+// telemetry stays off if none of them is set. The returned function flushes
+// what is left to send and must be called before exiting.
+func setupTelemetry(ctx context.Context, endpoint string) (func(), error) {
+	if endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" &&
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" &&
+		os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT") == "" {
+		return func() {}, nil
+	}
+
+	var traceOpts []otlptracehttp.Option
+	var metricOpts []otlpmetrichttp.Option
+	if endpoint != "" {
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		traceOpts = append(traceOpts, otlptracehttp.WithEndpointURL(endpoint+"/v1/traces"))
+		metricOpts = append(metricOpts, otlpmetrichttp.WithEndpointURL(endpoint+"/v1/metrics"))
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, traceOpts...)
+	if err != nil {
+		return nil, err
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, metricOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the
+	// default service name.
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("awswhois")))
+	if err != nil {
+		return nil, err
+	}
+	res, err = resource.Merge(res, resource.Environment())
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Debug("sending telemetry", "err", err)
+	}))
+	otel.SetMeterProvider(mp)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx)); err != nil {
+			slog.Warn("flushing telemetry", "err", err)
+		}
+	}, nil
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// recordLookup counts the outcome of a lookup and how long it took.
+func recordLookup(ctx context.Context, start time.Time, result Result, err error) {
+	outcome := "unmatched"
+	switch {
+	case err != nil:
+		outcome = "error"
+	case len(result.matchedPrefixes()) > 0:
+		outcome = "matched"
+	}
+	attrs := metric.WithAttributes(attribute.String("outcome", outcome))
+	lookupCounter.Add(ctx, 1, attrs)
+	lookupDuration.Record(ctx, time.Since(start).Seconds(), attrs)
+}
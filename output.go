@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+// tableOptions tells which extra sections are printed after the results.
+type tableOptions struct {
+	Describe bool
+	Related  bool
+
+	// Annotations adds a TAGS column when set.
+	Annotations annotations
+
+	// Columns are the columns of the table, in order; nil means the
+	// default ones. NoHeader leaves out the header row, also in the CSV and
+	// TSV outputs.
+	Columns  []string
+	NoHeader bool
+
+	// Color adds ANSI colors to the table.
+	Color bool
+
+	// Layout is layoutWide, layoutNarrow, or empty to use the narrow layout
+	// only when the table is wider than Width, the width of the terminal,
+	// which is 0 when the output isn't one.
+	Layout string
+	Width  int
+
+	// SortBy is the field the rows are sorted by, one of sortKeys, in
+	// descending order with Reverse. The rows are in lookup order otherwise.
+	SortBy  string
+	Reverse bool
+
+	// Metadata adds a description of the AWS IP ranges to the json, ndjson,
+	// and yaml outputs.
+	Metadata bool
+
+	// Query is the --query applied to the json and yaml outputs.
+	Query *jmespath.JMESPath
+
+	// Policy is what the github, junit, and nagios outputs check the IPs
+	// against.
+	Policy policy
+}
+
+// sortKeys are the values of --sort-by.
+var sortKeys = []string{"ip", "prefix", "region", "service"}
+
+// compare compares two values of the SortBy field. IPs and prefixes are
+// compared as addresses, the rest as text, and the empty values, e.g. the
+// prefix of an IP that isn't in AWS, come last even with Reverse.
+func (o tableOptions) compare(a, b string) int {
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	c := strings.Compare(a, b)
+	if o.SortBy == "ip" || o.SortBy == "prefix" {
+		pa, okA := labelPrefix(a)
+		pb, okB := labelPrefix(b)
+		switch {
+		case okA && okB:
+			c = comparePrefixes(pa, pb)
+		case okA:
+			return -1
+		case okB:
+			return 1
+		}
+	}
+	if o.Reverse {
+		return -c
+	}
+	return c
+}
+
+// columnHeader returns the header of a column, e.g. BORDER GROUP.
+func columnHeader(col string) string {
+	return strings.ToUpper(strings.ReplaceAll(col, "-", " "))
+}
+
+// tableWidth returns the width of the table once tabwriter has aligned its
+// columns, two spaces apart, leaving out the errors.
+func tableWidth(columns []string, rows []map[string]string, values func(map[string]string) []string) int {
+	widths := make([]int, len(columns))
+	for i, col := range columns {
+		widths[i] = len(columnHeader(col))
+	}
+	for _, cells := range rows {
+		// An error doesn't fit in any layout.
+		if cells["error"] != "" {
+			continue
+		}
+		for i, v := range values(cells) {
+			widths[i] = max(widths[i], utf8.RuneCountInString(v))
+		}
+	}
+	total := 2 * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+	return total
+}
+
+// labelPrefix parses the label of a subject, which is an IP with or without
+// a port, or a CIDR block of an IP range. The inputs that couldn't be looked
+// up aren't IPs.
+func labelPrefix(label string) (netip.Prefix, bool) {
+	if p, err := netip.ParsePrefix(label); err == nil {
+		return p, true
+	}
+	addr, err := netip.ParseAddr(label)
+	if err != nil {
+		ap, err := netip.ParseAddrPort(label)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		addr = ap.Addr()
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+// The colors of the table all have the same length, so that tabwriter, which
+// counts them as text, still aligns the columns.
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[01m"
+	colorDim    = "\x1b[02m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+func (o tableOptions) paint(color, s string) string {
+	if !o.Color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// useColor tells whether to color the output written to f, given --color:
+// always, never, or auto, which colors it when f is a terminal unless
+// NO_COLOR is set or TERM is dumb.
+func useColor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("unknown --color %q, must be one of: auto, always, never", mode)
+}
+
+// tableColumns are the values of --columns. TARGET is the input the IP comes
+// from, e.g. a hostname, and PTR is the reverse DNS name of the IP.
+var tableColumns = []string{"target", "ip", "ptr", "prefix", "overlap", "region", "service", "border-group", "zone-type", "tags"}
+
+// narrowColumns are the columns of the narrow layout, for 80-column
+// terminals.
+var narrowColumns = []string{"ip", "prefix", "region", "service"}
+
+// The layouts of the table: wide adds the PTR column and narrow keeps the
+// narrowColumns and collapses the services.
+const (
+	layoutWide   = "wide"
+	layoutNarrow = "narrow"
+)
+
+func defaultColumns(withTags bool) []string {
+	columns := []string{"ip", "prefix", "region", "service", "border-group", "zone-type"}
+	if withTags {
+		columns = append(columns, "tags")
+	}
+	return columns
+}
+
+// columns returns the columns of the table given --columns and the layout.
+func (o tableOptions) columns() []string {
+	switch {
+	case o.Columns != nil:
+		return o.Columns
+	case o.Layout == layoutNarrow:
+		return narrowColumns
+	case o.Layout == layoutWide:
+		return slices.Insert(defaultColumns(o.Annotations != nil), 1, "ptr")
+	}
+	return defaultColumns(o.Annotations != nil)
+}
+
+// collapseServices keeps the first of the comma-separated services and counts
+// the others, e.g. "AMAZON +2".
+func collapseServices(services string) string {
+	first, rest, ok := strings.Cut(services, ",")
+	if !ok {
+		return services
+	}
+	return fmt.Sprintf("%s +%d", first, strings.Count(rest, ",")+1)
+}
+
+// ptrTimeout bounds each reverse DNS lookup of the PTR column.
+const ptrTimeout = 2 * time.Second
+
+// lookupPTR returns the first reverse DNS name of the IP of the label, or an
+// empty string when there is none.
+func lookupPTR(label string) string {
+	p, ok := labelPrefix(label)
+	if !ok || p.Bits() != p.Addr().BitLen() {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ptrTimeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, p.Addr().String())
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// parseColumns parses the comma-separated value of --columns. Underscores can
+// be used instead of dashes, e.g. border_group.
+func parseColumns(s string) ([]string, error) {
+	var columns []string
+	for _, col := range splitList(s) {
+		col = strings.ReplaceAll(strings.ToLower(col), "_", "-")
+		if !slices.Contains(tableColumns, col) {
+			return nil, fmt.Errorf("unknown column %q, must be one of: %s", col, strings.Join(tableColumns, ", "))
+		}
+		columns = append(columns, col)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns given, must be some of: %s", strings.Join(tableColumns, ", "))
+	}
+	return columns, nil
+}
+
+// printResults writes the results as a table followed by the sections enabled
+// in opts. Notes meant for humans go to errOut. It returns true if at least
+// one AWS match was found.
+func printResults(out, errOut io.Writer, results []Result, ranges *AWSIPRanges, opts tableOptions) bool {
+	columns := opts.columns()
+	narrow := opts.Layout == layoutNarrow
+
+	// values returns the cells of the selected columns. An error, or what an
+	// IP without matches is when it's private or reserved, is shown in the
+	// ZONE TYPE column or, when it isn't selected, in the last one.
+	values := func(cells map[string]string) []string {
+		msg, ok := cells["error"]
+		if !ok {
+			msg, ok = cells["class"]
+		}
+		if ok {
+			col := columns[len(columns)-1]
+			if slices.Contains(columns, "zone-type") {
+				col = "zone-type"
+			}
+			if len(columns) > 1 || col != "ip" && col != "target" {
+				cells[col] = msg
+			}
+		}
+		values := make([]string, len(columns))
+		for i, col := range columns {
+			values[i] = cells[col]
+			if values[i] == "" {
+				values[i] = "-"
+			}
+			if col == "service" && narrow && cells["error"] == "" {
+				values[i] = collapseServices(values[i])
+			}
+		}
+		return values
+	}
+	// writeRow writes the cells of a row. With colors, the AWS matches are
+	// green with the region highlighted, the --feed matches yellow, the IPs
+	// without matches are dimmed, and the errors are red.
+	writeRow := func(w io.Writer, cells map[string]string) {
+		color := colorGreen
+		switch {
+		case cells["error"] != "":
+			color = colorRed
+		case cells["prefix"] == "":
+			color = colorDim
+		case cells["feed"] != "":
+			color = colorYellow
+		}
+		values := values(cells)
+		for i, col := range columns {
+			if col == "region" && color == colorGreen {
+				values[i] = opts.paint(colorCyan, values[i])
+			} else {
+				values[i] = opts.paint(color, values[i])
+			}
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+
+	found := false
+	var rows []map[string]string
+	var services, wavelength, matchedPrefixes []string
+	for _, result := range results {
+		if result.Err != nil {
+			rows = append(rows, map[string]string{"target": result.Target.Input, "ip": result.Target.Input, "error": fmt.Sprintf("error: %v", result.Err)})
+			continue
+		}
+		for _, subject := range result.Subjects {
+			if len(subject.Matches) == 0 && len(subject.Custom) == 0 {
+				row := map[string]string{"target": result.Target.Input, "ip": subject.Label}
+				if class := specialClass(subject.Label); class != "" {
+					row["class"] = class
+				}
+				rows = append(rows, row)
+				continue
+			}
+
+			// Matches against the --feed prefixes are shown but don't
+			// count as being in AWS.
+			if len(subject.Matches) > 0 {
+				found = true
+			}
+			for _, match := range subject.Matches {
+				if !slices.Contains(services, match.Service) {
+					services = append(services, match.Service)
+				}
+			}
+			// Group matches by IP + Prefix + Region + NetworkBorderGroup
+			grouped := groupMatches(slices.Concat(subject.Matches, subject.Custom))
+			for _, group := range grouped {
+				if group.Feed == "" && !slices.Contains(matchedPrefixes, group.Prefix) {
+					matchedPrefixes = append(matchedPrefixes, group.Prefix)
+				}
+				if group.ZoneType == ZoneTypeWavelength {
+					wavelength = append(wavelength, fmt.Sprintf("%s is in Wavelength Zone %s", subject.Label, group.NetworkBorderGroup))
+				}
+				rows = append(rows, map[string]string{
+					"target":       result.Target.Input,
+					"ip":           subject.Label,
+					"prefix":       group.Prefix,
+					"overlap":      overlapType(subject.Label, group.Prefix),
+					"region":       group.Region,
+					"service":      group.Services,
+					"border-group": group.NetworkBorderGroup,
+					"zone-type":    zoneLabel(group),
+					"tags":         formatTags(opts.Annotations.tagsForGroup(group)),
+					"feed":         group.Feed,
+				})
+			}
+		}
+	}
+	// The CIDR blocks and IP ranges get an OVERLAP column that tells whether
+	// each AWS prefix contains the block or is contained in it.
+	hasRanges := slices.ContainsFunc(results, func(r Result) bool { return r.Target.Range != nil })
+	if hasRanges && opts.Columns == nil && !narrow {
+		columns = slices.Insert(columns, slices.Index(columns, "prefix")+1, "overlap")
+	}
+	if opts.SortBy != "" {
+		slices.SortStableFunc(rows, func(a, b map[string]string) int {
+			return opts.compare(a[opts.SortBy], b[opts.SortBy])
+		})
+	}
+	if slices.Contains(columns, "ptr") {
+		ptrs := make(map[string]string)
+		for _, cells := range rows {
+			if _, ok := ptrs[cells["ip"]]; !ok {
+				ptrs[cells["ip"]] = lookupPTR(cells["ip"])
+			}
+			cells["ptr"] = ptrs[cells["ip"]]
+		}
+	}
+
+	// Without --wide, --narrow, or --columns, a table wider than the
+	// terminal switches to the narrow layout rather than wrapping.
+	if opts.Layout == "" && opts.Columns == nil && opts.Width > 0 && tableWidth(columns, rows, values) > opts.Width {
+		columns, narrow = narrowColumns, true
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if !opts.NoHeader {
+		headers := make([]string, len(columns))
+		for i, col := range columns {
+			headers[i] = opts.paint(colorBold, columnHeader(col))
+		}
+		fmt.Fprintln(w, strings.Join(headers, "\t"))
+	}
+	for _, cells := range rows {
+		writeRow(w, cells)
+	}
+	w.Flush()
+
+	var rangeResults []Result
+	for _, result := range results {
+		if result.Target.Range != nil {
+			rangeResults = append(rangeResults, result)
+		}
+	}
+	if len(rangeResults) > 0 {
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RANGE\tCIDRS\tADDRESSES\tIN AWS\tCOVERAGE")
+		for _, result := range rangeResults {
+			r := result.Target.Range
+			size, covered := r.Size(), r.Coverage(result.matchedPrefixes())
+			percent, _ := new(big.Rat).SetFrac(new(big.Int).Mul(covered, big.NewInt(100)), size).Float64()
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%.1f%%\n", result.Target.Input, len(result.Subjects), size, covered, percent)
+		}
+		w.Flush()
+	}
+
+	// A hostname that resolves to several IPs, e.g. a load balancer, gets a
+	// summary of where its IPs are, since its rows only show the IPs.
+	var hostResults []Result
+	for _, result := range results {
+		if result.Err == nil && result.Target.Range == nil && len(result.Subjects) > 1 {
+			hostResults = append(hostResults, result)
+		}
+	}
+	if len(hostResults) > 0 {
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tIPS IN AWS\tREGIONS")
+		for _, result := range hostResults {
+			inAWS := 0
+			var regions []string
+			for _, subject := range result.Subjects {
+				if len(subject.Matches) > 0 {
+					inAWS++
+				}
+				for _, match := range subject.Matches {
+					if !slices.Contains(regions, match.Region) {
+						regions = append(regions, match.Region)
+					}
+				}
+			}
+			slices.Sort(regions)
+			if len(regions) == 0 {
+				regions = []string{"-"}
+			}
+			fmt.Fprintf(w, "%s\t%d/%d\t%s\n", result.Target.Input, inAWS, len(result.Subjects), strings.Join(regions, ", "))
+		}
+		w.Flush()
+	}
+
+	// Wavelength Zones live inside carrier networks: the traffic comes from
+	// mobile devices on that carrier's 5G network, not from a datacenter.
+	for _, note := range wavelength {
+		fmt.Fprintf(errOut, "Note: %s; traffic originates from a carrier 5G network\n", note)
+	}
+
+	if opts.Related && len(matchedPrefixes) > 0 {
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PREFIX\tRELATION\tRELATED PREFIX\tREGION\tSERVICE\tBORDER GROUP")
+		for _, prefix := range matchedPrefixes {
+			rel := findRelatedPrefixes(prefix, ranges)
+			for _, group := range rel.Supernets {
+				fmt.Fprintf(w, "%s\tsubnet of\t%s\t%s\t%s\t%s\n", prefix, group.Prefix, group.Region, group.Services, group.NetworkBorderGroup)
+			}
+			for i, group := range rel.Subnets {
+				if i == maxRelatedSubnets {
+					fmt.Fprintf(w, "%s\tsupernet of\t(%d more)\t\t\t\n", prefix, len(rel.Subnets)-maxRelatedSubnets)
+					break
+				}
+				fmt.Fprintf(w, "%s\tsupernet of\t%s\t%s\t%s\t%s\n", prefix, group.Prefix, group.Region, group.Services, group.NetworkBorderGroup)
+			}
+			if len(rel.Supernets) == 0 && len(rel.Subnets) == 0 {
+				fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\n", prefix)
+			}
+		}
+		w.Flush()
+	}
+
+	if opts.Describe && len(services) > 0 {
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tDESCRIPTION\tDOCS")
+		for _, svc := range services {
+			info := describeService(svc)
+			fmt.Fprintf(w, "%s\t%s\t%s\n", svc, info.Description, info.DocsURL)
+		}
+		w.Flush()
+	}
+
+	return found
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"text/tabwriter"
+)
+
+// The link types of the captures that can be read, from
+// https://www.tcpdump.org/linktypes.html.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+)
+
+// maxSnaplen caps the length of the records, whatever the snaplen of the
+// capture says, so that a corrupt length doesn't allocate gigabytes. It is
+// the largest snaplen of tcpdump and Wireshark.
+const maxSnaplen = 256 * 1024
+
+// pcapPacket is the IP layer of a captured packet. Length is the length of
+// the packet on the wire, which may be longer than what was captured.
+type pcapPacket struct {
+	Src, Dst netip.Addr
+	Length   int
+}
+
+// pcapReader reads the packets of a capture in the classic libpcap format, as
+// written by tcpdump -w.
+type pcapReader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	snaplen  uint32
+	linkType uint32
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("reading the pcap header: %w", err)
+	}
+	p := &pcapReader{r: br}
+	switch binary.LittleEndian.Uint32(header) {
+	case 0xa1b2c3d4, 0xa1b23c4d: // microsecond and nanosecond timestamps
+		p.order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		p.order = binary.BigEndian
+	case 0x0a0d0d0a:
+		return nil, errors.New("pcapng isn't supported, convert the capture with: editcap -F pcap in.pcapng out.pcap")
+	default:
+		return nil, errors.New("not a pcap file")
+	}
+	p.snaplen = p.order.Uint32(header[16:])
+	if p.snaplen == 0 || p.snaplen > maxSnaplen {
+		p.snaplen = maxSnaplen
+	}
+	p.linkType = p.order.Uint32(header[20:]) & 0xffff
+	switch p.linkType {
+	case linkTypeNull, linkTypeEthernet, linkTypeRaw, linkTypeLinuxSLL:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", p.linkType)
+	}
+	return p, nil
+}
+
+// next returns the next IPv4 or IPv6 packet, skipping the others such as ARP,
+// and io.EOF at the end of the capture.
+func (p *pcapReader) next() (pcapPacket, error) {
+	record := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(p.r, record); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return pcapPacket{}, errors.New("truncated pcap file")
+			}
+			return pcapPacket{}, err
+		}
+		caplen := p.order.Uint32(record[8:])
+		if caplen > p.snaplen {
+			return pcapPacket{}, fmt.Errorf("corrupt pcap record: %d bytes captured, more than the snaplen of %d", caplen, p.snaplen)
+		}
+		data := make([]byte, caplen)
+		if _, err := io.ReadFull(p.r, data); err != nil {
+			return pcapPacket{}, errors.New("truncated pcap file")
+		}
+		packet, ok := p.decode(data)
+		if !ok {
+			continue
+		}
+		// What the link layer adds isn't counted, so that the lengths are
+		// the same whatever the link type.
+		packet.Length = int(p.order.Uint32(record[12:])) - (len(data) - packet.Length)
+		return packet, nil
+	}
+}
+
+// decode strips the link layer and reads the addresses of the IP header.
+// Length is set to the length of the captured data from the IP header on.
+func (p *pcapReader) decode(data []byte) (pcapPacket, bool) {
+	var etherType uint16
+	switch p.linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return pcapPacket{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:]), data[14:]
+		// 802.1Q and 802.1ad VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return pcapPacket{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:]), data[16:]
+	case linkTypeNull:
+		// The address family in the byte order of the host that captured.
+		if len(data) < 4 {
+			return pcapPacket{}, false
+		}
+		data = data[4:]
+	}
+	if len(data) == 0 {
+		return pcapPacket{}, false
+	}
+
+	version := data[0] >> 4
+	switch {
+	case version == 4 && (etherType == 0 || etherType == 0x0800) && len(data) >= 20:
+		return pcapPacket{
+			Src:    netip.AddrFrom4([4]byte(data[12:16])),
+			Dst:    netip.AddrFrom4([4]byte(data[16:20])),
+			Length: len(data),
+		}, true
+	case version == 6 && (etherType == 0 || etherType == 0x86dd) && len(data) >= 40:
+		return pcapPacket{
+			Src:    netip.AddrFrom16([16]byte(data[8:24])),
+			Dst:    netip.AddrFrom16([16]byte(data[24:40])),
+			Length: len(data),
+		}, true
+	}
+	return pcapPacket{}, false
+}
+
+// pcapFlow is the traffic from one IP to another.
+type pcapFlow struct {
+	Src, Dst netip.Addr
+	Packets  int
+	Bytes    int64
+}
+
+// runPcap implements "awswhois pcap", which reports the flows of a capture
+// that are to or from AWS, with their packets and bytes summed by AWS region
+// and service, e.g. for a quick look at the egress of a host during an
+// incident.
+func runPcap(args []string, source rangesSource, opts lookupOptions, concurrency int, out, errOut io.Writer) error {
+	fs := flag.NewFlagSet("pcap", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] pcap <capture.pcap>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usagef("pcap takes a capture file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := newPcapReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	type flowKey struct{ Src, Dst netip.Addr }
+	byKey := make(map[flowKey]*pcapFlow)
+	var flows []*pcapFlow
+	var ips []netip.Addr
+	seen := make(map[netip.Addr]bool)
+	for {
+		packet, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", fs.Arg(0), err)
+		}
+		key := flowKey{packet.Src.Unmap(), packet.Dst.Unmap()}
+		flow, ok := byKey[key]
+		if !ok {
+			flow = &pcapFlow{Src: key.Src, Dst: key.Dst}
+			byKey[key] = flow
+			flows = append(flows, flow)
+		}
+		flow.Packets++
+		flow.Bytes += int64(packet.Length)
+		for _, ip := range []netip.Addr{key.Src, key.Dst} {
+			if !seen[ip] {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	inputs := make([]TargetLine, len(ips))
+	for i, ip := range ips {
+		inputs[i] = TargetLine{Num: i + 1, Text: ip.String()}
+	}
+	outcome := lookupAll(context.Background(), inputs, concurrency, ranges, opts, errOut)
+	type location struct{ Region, Service string }
+	where := make(map[netip.Addr]location)
+	for i, ip := range ips {
+		if o := outcome(i); o.Err == nil {
+			if region, ok := printValue(o.Result, "region"); ok {
+				service, _ := printValue(o.Result, "service")
+				where[ip] = location{region, service}
+			}
+		}
+	}
+
+	type group struct {
+		location
+		Flows, Packets int
+		Bytes          int64
+	}
+	var groups []*group
+	var awsFlows []*pcapFlow
+	var total, fromAWS pcapFlow
+	for _, flow := range flows {
+		total.Packets += flow.Packets
+		total.Bytes += flow.Bytes
+		// The AWS end of the flow, the destination when both are.
+		loc, ok := where[flow.Dst]
+		if !ok {
+			if loc, ok = where[flow.Src]; !ok {
+				continue
+			}
+		}
+		awsFlows = append(awsFlows, flow)
+		fromAWS.Packets += flow.Packets
+		fromAWS.Bytes += flow.Bytes
+		i := slices.IndexFunc(groups, func(g *group) bool { return g.location == loc })
+		if i < 0 {
+			groups = append(groups, &group{location: loc})
+			i = len(groups) - 1
+		}
+		groups[i].Flows++
+		groups[i].Packets += flow.Packets
+		groups[i].Bytes += flow.Bytes
+	}
+
+	fmt.Fprintf(out, "%d of %d flows, %d of %d packets, and %d of %d bytes were to or from AWS\n",
+		len(awsFlows), len(flows), fromAWS.Packets, total.Packets, fromAWS.Bytes, total.Bytes)
+	if len(awsFlows) == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(groups, func(a, b *group) int { return cmp.Compare(b.Bytes, a.Bytes) })
+	slices.SortStableFunc(awsFlows, func(a, b *pcapFlow) int { return cmp.Compare(b.Bytes, a.Bytes) })
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nREGION\tSERVICE\tFLOWS\tPACKETS\tBYTES")
+	for _, g := range groups {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", g.Region, g.Service, g.Flows, g.Packets, g.Bytes)
+	}
+	fmt.Fprintln(w, "\nSOURCE\tDESTINATION\tREGION\tSERVICE\tPACKETS\tBYTES")
+	for _, flow := range awsFlows {
+		loc, ok := where[flow.Dst]
+		if !ok {
+			loc = where[flow.Src]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n", flow.Src, flow.Dst, loc.Region, loc.Service, flow.Packets, flow.Bytes)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// pcapRecord is a captured packet: the data as captured and its length on
+// the wire, the same as len(data) when 0.
+type pcapRecord struct {
+	data    []byte
+	wireLen int
+}
+
+// buildPcap writes a capture in the classic libpcap format.
+func buildPcap(order binary.ByteOrder, magic, snaplen, linkType uint32, records ...pcapRecord) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, order, struct {
+		Magic             uint32
+		Major, Minor      uint16
+		ThisZone, SigFigs uint32
+		Snaplen, LinkType uint32
+	}{magic, 2, 4, 0, 0, snaplen, linkType})
+	for _, r := range records {
+		wireLen := r.wireLen
+		if wireLen == 0 {
+			wireLen = len(r.data)
+		}
+		binary.Write(&b, order, [4]uint32{1714640000, 0, uint32(len(r.data)), uint32(wireLen)})
+		b.Write(r.data)
+	}
+	return b.Bytes()
+}
+
+// ipv4Packet is an IPv4 header followed by payload bytes.
+func ipv4Packet(src, dst string, payload int) []byte {
+	p := make([]byte, 20+payload)
+	p[0] = 0x45
+	copy(p[12:], netip.MustParseAddr(src).AsSlice())
+	copy(p[16:], netip.MustParseAddr(dst).AsSlice())
+	return p
+}
+
+// ipv6Packet is an IPv6 header followed by payload bytes.
+func ipv6Packet(src, dst string, payload int) []byte {
+	p := make([]byte, 40+payload)
+	p[0] = 0x60
+	copy(p[8:], netip.MustParseAddr(src).AsSlice())
+	copy(p[24:], netip.MustParseAddr(dst).AsSlice())
+	return p
+}
+
+// ethernet wraps the packet in an Ethernet frame with the VLAN tags given.
+func ethernet(etherType uint16, packet []byte, vlans ...uint16) []byte {
+	frame := make([]byte, 12)
+	for _, tpid := range vlans {
+		frame = binary.BigEndian.AppendUint16(frame, tpid)
+		frame = binary.BigEndian.AppendUint16(frame, 42)
+	}
+	frame = binary.BigEndian.AppendUint16(frame, etherType)
+	return append(frame, packet...)
+}
+
+func readPackets(capture []byte) ([]pcapPacket, error) {
+	r, err := newPcapReader(bytes.NewReader(capture))
+	if err != nil {
+		return nil, err
+	}
+	var packets []pcapPacket
+	for {
+		packet, err := r.next()
+		if err == io.EOF {
+			return packets, nil
+		}
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, packet)
+	}
+}
+
+func TestPcapReader(t *testing.T) {
+	v4 := ipv4Packet("10.0.0.1", "52.94.76.1", 80)
+	v6 := ipv6Packet("2001:db8::1", "2600:1f18::1", 60)
+	wantV4 := pcapPacket{Src: netip.MustParseAddr("10.0.0.1"), Dst: netip.MustParseAddr("52.94.76.1"), Length: 100}
+	wantV6 := pcapPacket{Src: netip.MustParseAddr("2001:db8::1"), Dst: netip.MustParseAddr("2600:1f18::1"), Length: 100}
+	sll := func(etherType uint16, packet []byte) []byte {
+		header := make([]byte, 14)
+		return append(binary.BigEndian.AppendUint16(header, etherType), packet...)
+	}
+	null := func(family uint32, packet []byte) []byte {
+		return append(binary.LittleEndian.AppendUint32(nil, family), packet...)
+	}
+
+	tests := []struct {
+		name    string
+		capture []byte
+		want    []pcapPacket
+		wantErr string
+	}{
+		{
+			name:    "Ethernet, little-endian",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeEthernet, pcapRecord{data: ethernet(0x0800, v4)}, pcapRecord{data: ethernet(0x86dd, v6)}),
+			want:    []pcapPacket{wantV4, wantV6},
+		},
+		{
+			name:    "Ethernet, big-endian",
+			capture: buildPcap(binary.BigEndian, 0xa1b2c3d4, 65535, linkTypeEthernet, pcapRecord{data: ethernet(0x0800, v4)}),
+			want:    []pcapPacket{wantV4},
+		},
+		{
+			name:    "nanosecond timestamps",
+			capture: buildPcap(binary.LittleEndian, 0xa1b23c4d, 65535, linkTypeEthernet, pcapRecord{data: ethernet(0x0800, v4)}),
+			want:    []pcapPacket{wantV4},
+		},
+		{
+			name:    "nanosecond timestamps, big-endian",
+			capture: buildPcap(binary.BigEndian, 0xa1b23c4d, 65535, linkTypeEthernet, pcapRecord{data: ethernet(0x86dd, v6)}),
+			want:    []pcapPacket{wantV6},
+		},
+		{
+			name:    "802.1Q and 802.1ad VLAN tags",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeEthernet, pcapRecord{data: ethernet(0x0800, v4, 0x88a8, 0x8100)}),
+			want:    []pcapPacket{wantV4},
+		},
+		{
+			name:    "ARP is skipped",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeEthernet, pcapRecord{data: ethernet(0x0806, make([]byte, 28))}, pcapRecord{data: ethernet(0x0800, v4)}),
+			want:    []pcapPacket{wantV4},
+		},
+		{
+			name:    "truncated by the snaplen",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 54, linkTypeEthernet, pcapRecord{data: ethernet(0x0800, v4)[:54], wireLen: 1514}),
+			want:    []pcapPacket{{Src: wantV4.Src, Dst: wantV4.Dst, Length: 1500}},
+		},
+		{
+			name:    "Linux cooked capture",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeLinuxSLL, pcapRecord{data: sll(0x0800, v4)}, pcapRecord{data: sll(0x86dd, v6)}),
+			want:    []pcapPacket{wantV4, wantV6},
+		},
+		{
+			name:    "raw IP",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeRaw, pcapRecord{data: v4}, pcapRecord{data: v6}),
+			want:    []pcapPacket{wantV4, wantV6},
+		},
+		{
+			name:    "BSD loopback",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeNull, pcapRecord{data: null(2, v4)}, pcapRecord{data: null(30, v6)}),
+			want:    []pcapPacket{wantV4, wantV6},
+		},
+		{
+			name:    "pcapng",
+			capture: []byte{0x0a, 0x0d, 0x0d, 0x0a, 0x1c, 0, 0, 0, 0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			wantErr: "pcapng isn't supported",
+		},
+		{
+			name:    "not a pcap file",
+			capture: []byte(strings.Repeat("GET / HTTP/1.1\r\n", 2)),
+			wantErr: "not a pcap file",
+		},
+		{
+			name:    "truncated header",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeEthernet)[:10],
+			wantErr: "reading the pcap header",
+		},
+		{
+			name:    "unsupported link type",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, 105),
+			wantErr: "unsupported link type 105",
+		},
+		{
+			name:    "truncated record header",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeRaw, pcapRecord{data: v4})[:24+8],
+			wantErr: "truncated pcap file",
+		},
+		{
+			name:    "truncated record data",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 65535, linkTypeRaw, pcapRecord{data: v4})[:24+16+10],
+			wantErr: "truncated pcap file",
+		},
+		{
+			name:    "record longer than the snaplen",
+			capture: buildPcap(binary.LittleEndian, 0xa1b2c3d4, 64, linkTypeRaw, pcapRecord{data: v4}),
+			wantErr: "corrupt pcap record",
+		},
+		{
+			name:    "record longer than the cap",
+			capture: buildPcap(binary.BigEndian, 0xa1b2c3d4, 0xffffffff, linkTypeRaw, pcapRecord{data: make([]byte, maxSnaplen+1)}),
+			wantErr: "corrupt pcap record",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPackets(tt.capture)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// progressInterval is how often the progress bar is redrawn at most.
+const progressInterval = 100 * time.Millisecond
+
+// progress is the bar shown on stderr while a batch is looked up, with how
+// many targets are in AWS, outside AWS, or couldn't be looked up.
+type progress struct {
+	w     io.Writer
+	total int
+
+	done, matched, unmatched, errors int
+	drawn                            time.Time
+}
+
+func newProgress(w io.Writer, total int) *progress {
+	return &progress{w: w, total: total}
+}
+
+// add counts the result and redraws the bar, unless it was redrawn less than
+// progressInterval ago.
+func (p *progress) add(result Result) {
+	p.done++
+	switch {
+	case result.Err != nil:
+		p.errors++
+	case foundInAWS([]Result{result}):
+		p.matched++
+	default:
+		p.unmatched++
+	}
+	if p.done == p.total || time.Since(p.drawn) >= progressInterval {
+		p.draw()
+	}
+}
+
+func (p *progress) draw() {
+	const width = 30
+	filled := width * p.done / max(1, p.total)
+	fmt.Fprintf(p.w, "\r[%s%s] %d/%d  in AWS %d  not in AWS %d  errors %d\x1b[K",
+		strings.Repeat("=", filled), strings.Repeat(" ", width-filled),
+		p.done, p.total, p.matched, p.unmatched, p.errors)
+	p.drawn = time.Now()
+}
+
+// clear erases the bar so that a line of output can be written in its place.
+// The bar is drawn again by the next add.
+func (p *progress) clear() {
+	fmt.Fprint(p.w, "\r\x1b[K")
+	p.drawn = time.Time{}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// aclName turns a service name into a name usable in proxy configs, e.g.
+// aws_ec2_instance_connect for EC2_INSTANCE_CONNECT.
+func aclName(prefix, service string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(service))
+	return prefix + "_" + name
+}
+
+// exportSquid writes a Squid dst ACL file per service into --dir, atomically
+// so that a running Squid can be reconfigured at any time, and prints the
+// acl and http_access lines that use them. Without --dir, the prefixes of
+// all the services are written as a single ACL file to stdout.
+func exportSquid(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export squid", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	dir := fs.String("dir", "", "write one ACL file per service, named <acl-prefix>_<service>.txt, into this directory")
+	prefix := fs.String("acl-prefix", "aws", "prefix of the ACL names and files")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		prefixes, err := req.prefixes()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "# %s\n", req.header())
+		fmt.Fprintf(out, "# acl %s dst \"/etc/squid/%s.txt\"\n", *prefix, *prefix)
+		fmt.Fprintf(out, "# http_access allow %s\n", *prefix)
+		for _, p := range prefixes {
+			fmt.Fprintln(out, p)
+		}
+		return nil
+	}
+
+	services, err := req.services()
+	if err != nil {
+		return err
+	}
+	absDir, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+	var acls, rules bytes.Buffer
+	for _, svc := range services {
+		name := aclName(*prefix, svc.Service)
+		path := filepath.Join(absDir, name+".txt")
+
+		var body bytes.Buffer
+		fmt.Fprintf(&body, "# %s\n", req.header())
+		for _, p := range svc.Prefixes {
+			fmt.Fprintln(&body, p)
+		}
+		if err := writeFileAtomic(path, body.Bytes()); err != nil {
+			return err
+		}
+		fmt.Fprintf(&acls, "acl %s dst %q\n", name, path)
+		fmt.Fprintf(&rules, "http_access allow %s\n", name)
+	}
+	_, err = fmt.Fprintf(out, "%s\n%s", acls.String(), rules.String())
+	return err
+}
+
+// exportHAProxy writes an HAProxy ACL file, loaded with "src -f" or "dst -f",
+// or with --map a map file that gives the service or region of an address
+// through map_ip.
+func exportHAProxy(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export haproxy", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	mapValue := fs.String("map", "", "write a map file whose values are the service or the region of each prefix (service, region)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *mapValue == "" {
+		prefixes, err := req.prefixes()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "# %s\n", req.header())
+		fmt.Fprintln(out, "# acl from_aws src -f /etc/haproxy/aws.acl")
+		for _, p := range prefixes {
+			fmt.Fprintln(out, p)
+		}
+		return nil
+	}
+
+	var value func(exportGroup) string
+	var header string
+	switch *mapValue {
+	case "service":
+		value = func(g exportGroup) string { return g.Service }
+		header = "X-AWS-Service"
+	case "region":
+		value = func(g exportGroup) string { return g.Region }
+		header = "X-AWS-Region"
+	default:
+		return usagef("unknown --map %q, must be service or region", *mapValue)
+	}
+	groups, err := req.groups()
+	if err != nil {
+		return err
+	}
+
+	// Each value gets its minimal list of prefixes. When the lists of two
+	// values share a prefix, its entry lists both since a map key can only
+	// appear once.
+	byValue := make(map[string][]netip.Prefix)
+	for _, g := range groups {
+		byValue[value(g)] = append(byValue[value(g)], g.Prefixes...)
+	}
+	values := make(map[netip.Prefix][]string)
+	for _, v := range slices.Sorted(maps.Keys(byValue)) {
+		for _, p := range req.minimize(byValue[v]) {
+			values[p] = append(values[p], v)
+		}
+	}
+
+	fmt.Fprintf(out, "# %s\n", req.header())
+	fmt.Fprintf(out, "# http-request set-header %s %%[src,map_ip(/etc/haproxy/aws.map,-)]\n", header)
+	for _, p := range slices.SortedFunc(maps.Keys(values), comparePrefixes) {
+		fmt.Fprintf(out, "%s %s\n", p, strings.Join(values[p], ","))
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Types of the events published for the changes found by --watch-file.
+const (
+	eventTargetAdded           = "target-added"
+	eventClassificationChanged = "classification-changed"
+)
+
+// ChangeEvent is the JSON document published to SNS, SQS, and EventBridge for
+// each change.
+// The version is bumped whenever a field is removed or changes meaning.
+type ChangeEvent struct {
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	Change
+}
+
+func newChangeEvent(change Change) ChangeEvent {
+	event := ChangeEvent{Version: "1", Type: eventClassificationChanged, Change: change}
+	if change.Before == nil {
+		event.Type = eventTargetAdded
+	}
+	return event
+}
+
+// dedupID is used as the deduplication ID of FIFO topics and queues so that
+// the same change published twice is only delivered once.
+func (e ChangeEvent) dedupID() string {
+	sum := sha256.Sum256([]byte(e.Target + "\x00" + e.Time.String() + "\x00" + e.After.Summary))
+	return hex.EncodeToString(sum[:])
+}
+
+// snsPublisher publishes one message per change to an SNS topic. The event
+// type and the target are also set as message attributes so that
+// subscriptions can use filter policies.
+type snsPublisher struct {
+	client   *sns.Client
+	topicARN string
+}
+
+func newSNSPublisher(ctx context.Context, topicARN string) (*snsPublisher, error) {
+	cfg, err := loadAWSConfig(ctx, regionFromARN(topicARN))
+	if err != nil {
+		return nil, err
+	}
+	return &snsPublisher{client: sns.NewFromConfig(cfg), topicARN: topicARN}, nil
+}
+
+func (p *snsPublisher) HandleChanges(ctx context.Context, changes []Change) error {
+	for _, change := range changes {
+		event := newChangeEvent(change)
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		input := &sns.PublishInput{
+			TopicArn: aws.String(p.topicARN),
+			Message:  aws.String(string(body)),
+			Subject:  aws.String(snsSubject(fmt.Sprintf("awswhois: %s %s", change.Target, event.Type))),
+			MessageAttributes: map[string]snstypes.MessageAttributeValue{
+				"type":   {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+				"target": {DataType: aws.String("String"), StringValue: aws.String(change.Target)},
+			},
+		}
+		if strings.HasSuffix(p.topicARN, ".fifo") {
+			input.MessageGroupId = aws.String(change.Target)
+			input.MessageDeduplicationId = aws.String(event.dedupID())
+		}
+		if _, err := p.client.Publish(ctx, input); err != nil {
+			return fmt.Errorf("publishing to %s: %w", p.topicARN, err)
+		}
+	}
+	return nil
+}
+
+// sqsPublisher sends one message per change to an SQS queue.
+type sqsPublisher struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+func newSQSPublisher(ctx context.Context, queueURL string) (*sqsPublisher, error) {
+	cfg, err := loadAWSConfig(ctx, regionFromQueueURL(queueURL))
+	if err != nil {
+		return nil, err
+	}
+	return &sqsPublisher{client: sqs.NewFromConfig(cfg), queueURL: queueURL}, nil
+}
+
+func (p *sqsPublisher) HandleChanges(ctx context.Context, changes []Change) error {
+	for _, change := range changes {
+		event := newChangeEvent(change)
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		input := &sqs.SendMessageInput{
+			QueueUrl:    aws.String(p.queueURL),
+			MessageBody: aws.String(string(body)),
+			MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+				"type":   {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+				"target": {DataType: aws.String("String"), StringValue: aws.String(change.Target)},
+			},
+		}
+		if strings.HasSuffix(p.queueURL, ".fifo") {
+			input.MessageGroupId = aws.String(change.Target)
+			input.MessageDeduplicationId = aws.String(event.dedupID())
+		}
+		if _, err := p.client.SendMessage(ctx, input); err != nil {
+			return fmt.Errorf("sending to %s: %w", p.queueURL, err)
+		}
+	}
+	return nil
+}
+
+// EventBridge source and detail-type of the events put on the bus. Rules can
+// match on them, e.g. {"source": ["awswhois"], "detail.type":
+// ["classification-changed"]}.
+const (
+	eventBridgeSource     = "awswhois"
+	eventBridgeDetailType = "AWS IP Classification Change"
+)
+
+// eventBridgeMaxEntries is the maximum number of entries PutEvents accepts.
+const eventBridgeMaxEntries = 10
+
+// eventBridgePublisher puts one event per change on an EventBridge bus. The
+// detail is the same ChangeEvent document as the one sent to SNS and SQS.
+type eventBridgePublisher struct {
+	client *eventbridge.Client
+	bus    string
+}
+
+func newEventBridgePublisher(ctx context.Context, bus string) (*eventBridgePublisher, error) {
+	cfg, err := loadAWSConfig(ctx, regionFromARN(bus))
+	if err != nil {
+		return nil, err
+	}
+	return &eventBridgePublisher{client: eventbridge.NewFromConfig(cfg), bus: bus}, nil
+}
+
+func (p *eventBridgePublisher) HandleChanges(ctx context.Context, changes []Change) error {
+	var entries []ebtypes.PutEventsRequestEntry
+	for _, change := range changes {
+		detail, err := json.Marshal(newChangeEvent(change))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, ebtypes.PutEventsRequestEntry{
+			EventBusName: aws.String(p.bus),
+			Source:       aws.String(eventBridgeSource),
+			DetailType:   aws.String(eventBridgeDetailType),
+			Detail:       aws.String(string(detail)),
+			Time:         aws.Time(change.Time),
+		})
+	}
+
+	for batch := range slices.Chunk(entries, eventBridgeMaxEntries) {
+		out, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: batch})
+		if err != nil {
+			return fmt.Errorf("putting events on %s: %w", p.bus, err)
+		}
+		// PutEvents succeeds even when some of the entries were rejected.
+		if out.FailedEntryCount > 0 {
+			for _, entry := range out.Entries {
+				if entry.ErrorCode != nil {
+					return fmt.Errorf("putting events on %s: %d entries failed, first error: %s: %s",
+						p.bus, out.FailedEntryCount, aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// loadAWSConfig loads the credentials the usual way (environment, shared
+// config, instance role...). The region of the resource is used unless one
+// is configured explicitly.
+func loadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = region
+	}
+	return cfg, nil
+}
+
+// regionFromARN returns the region of an ARN such as
+// arn:aws:sns:eu-west-1:123456789012:awswhois.
+func regionFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[3]
+}
+
+// regionFromQueueURL returns the region of a queue URL such as
+// https://sqs.eu-west-1.amazonaws.com/123456789012/awswhois.
+func regionFromQueueURL(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return ""
+	}
+	labels := strings.Split(u.Hostname(), ".")
+	if len(labels) < 3 || labels[0] != "sqs" {
+		return ""
+	}
+	return labels[1]
+}
+
+// truncate cuts s to at most n bytes, ending with "...", without splitting
+// a rune.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - 3
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// snsSubject makes s a valid SNS subject, which must be printable ASCII of
+// at most 100 characters: the other characters, e.g. of an IDN, are replaced
+// with '?'.
+func snsSubject(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '?'
+		}
+		return r
+	}, s)
+	return truncate(s, 100)
+}
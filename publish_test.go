@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSNSSubject(t *testing.T) {
+	tests := []struct {
+		name, subject, want string
+	}{
+		{"ASCII", "awswhois: api.example.com classification-changed", "awswhois: api.example.com classification-changed"},
+		{"IDN", "awswhois: bücher.example target-added", "awswhois: b?cher.example target-added"},
+		{"control characters", "awswhois: a\tb\nc target-added", "awswhois: a?b?c target-added"},
+		{"too long", "awswhois: " + strings.Repeat("a", 120), "awswhois: " + strings.Repeat("a", 87) + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snsSubject(tt.subject); got != tt.want {
+				t.Errorf("snsSubject(%q) = %q, want %q", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	// "é" is 2 bytes: cutting at 7 bytes would split the third one.
+	s := strings.Repeat("é", 10)
+	got := truncate(s, 10)
+	if !utf8.ValidString(got) || len(got) > 10 {
+		t.Errorf("truncate(%q, 10) = %q, want valid UTF-8 of at most 10 bytes", s, got)
+	}
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("truncate(%q, 10) = %q, want it unchanged", "short", got)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+// RegionLocation is roughly where the data centers of a region are, good
+// enough to put the region on a map. AWS doesn't publish exact locations; the
+// coordinates are the ones of the city the region is named after.
+type RegionLocation struct {
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// regionLocations maps region codes to their location. The country is an
+// ISO 3166-1 alpha-2 code. Local Zones and Wavelength Zones are given the
+// location of their parent region.
+var regionLocations = map[string]RegionLocation{
+	"af-south-1":     {"Cape Town", "ZA", -33.92, 18.42},
+	"ap-east-1":      {"Hong Kong", "HK", 22.32, 114.17},
+	"ap-east-2":      {"Taipei", "TW", 25.03, 121.57},
+	"ap-northeast-1": {"Tokyo", "JP", 35.68, 139.69},
+	"ap-northeast-2": {"Seoul", "KR", 37.57, 126.98},
+	"ap-northeast-3": {"Osaka", "JP", 34.69, 135.50},
+	"ap-south-1":     {"Mumbai", "IN", 19.08, 72.88},
+	"ap-south-2":     {"Hyderabad", "IN", 17.39, 78.49},
+	"ap-southeast-1": {"Singapore", "SG", 1.35, 103.82},
+	"ap-southeast-2": {"Sydney", "AU", -33.87, 151.21},
+	"ap-southeast-3": {"Jakarta", "ID", -6.21, 106.85},
+	"ap-southeast-4": {"Melbourne", "AU", -37.81, 144.96},
+	"ap-southeast-5": {"Kuala Lumpur", "MY", 3.14, 101.69},
+	"ap-southeast-6": {"Auckland", "NZ", -36.85, 174.76},
+	"ap-southeast-7": {"Bangkok", "TH", 13.76, 100.50},
+	"ca-central-1":   {"Montreal", "CA", 45.50, -73.57},
+	"ca-west-1":      {"Calgary", "CA", 51.05, -114.07},
+	"cn-north-1":     {"Beijing", "CN", 39.90, 116.40},
+	"cn-northwest-1": {"Ningxia", "CN", 37.51, 105.19},
+	"eu-central-1":   {"Frankfurt", "DE", 50.11, 8.68},
+	"eu-central-2":   {"Zurich", "CH", 47.38, 8.54},
+	"eu-north-1":     {"Stockholm", "SE", 59.33, 18.07},
+	"eu-south-1":     {"Milan", "IT", 45.46, 9.19},
+	"eu-south-2":     {"Aragon", "ES", 41.65, -0.88},
+	"eu-west-1":      {"Dublin", "IE", 53.35, -6.26},
+	"eu-west-2":      {"London", "GB", 51.51, -0.13},
+	"eu-west-3":      {"Paris", "FR", 48.86, 2.35},
+	"il-central-1":   {"Tel Aviv", "IL", 32.09, 34.78},
+	"me-central-1":   {"UAE", "AE", 24.47, 54.37},
+	"me-south-1":     {"Bahrain", "BH", 26.07, 50.56},
+	"mx-central-1":   {"Queretaro", "MX", 20.59, -100.39},
+	"sa-east-1":      {"Sao Paulo", "BR", -23.55, -46.63},
+	"us-east-1":      {"Northern Virginia", "US", 39.04, -77.49},
+	"us-east-2":      {"Ohio", "US", 39.96, -83.00},
+	"us-gov-east-1":  {"Ohio", "US", 39.96, -83.00},
+	"us-gov-west-1":  {"Oregon", "US", 45.84, -119.70},
+	"us-west-1":      {"Northern California", "US", 37.77, -122.42},
+	"us-west-2":      {"Oregon", "US", 45.84, -119.70},
+}
+
+// regionLocation returns the location of the region. There is none for
+// GLOBAL and for the regions added after this table was written.
+func regionLocation(region string) *RegionLocation {
+	if loc, ok := regionLocations[region]; ok {
+		return &loc
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/netip"
+)
+
+// maxRelatedSubnets caps how many subnets are listed for a single prefix. The
+// broad AMAZON aggregates contain hundreds of service carve-outs.
+const maxRelatedSubnets = 20
+
+// RelatedPrefixes lists the other AWS prefixes that contain a given prefix
+// (its supernets) or that are contained in it (its subnets).
+type RelatedPrefixes struct {
+	Prefix    string
+	Supernets []GroupedMatch
+	Subnets   []GroupedMatch
+}
+
+// findRelatedPrefixes tells whether a matched prefix is an aggregate
+// allocation (it has subnets) or a service-specific carve-out (it has
+// supernets).
+func findRelatedPrefixes(prefix string, ranges *AWSIPRanges) RelatedPrefixes {
+	related := RelatedPrefixes{Prefix: prefix}
+
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return related
+	}
+	p = p.Masked()
+
+	var supernets, subnets []AWSMatch
+	for _, match := range allPrefixes(ranges, p.Addr().Is4()) {
+		other, err := netip.ParsePrefix(match.Prefix)
+		if err != nil {
+			continue
+		}
+		other = other.Masked()
+
+		switch {
+		case other.Bits() < p.Bits() && other.Contains(p.Addr()):
+			supernets = append(supernets, match)
+		case other.Bits() > p.Bits() && p.Contains(other.Addr()):
+			subnets = append(subnets, match)
+		}
+	}
+
+	related.Supernets = groupMatches(supernets)
+	related.Subnets = groupMatches(subnets)
+	return related
+}
+
+// allPrefixes returns every IPv4 or IPv6 prefix of the dataset in the same
+// shape as a match so that they can be grouped the same way.
+func allPrefixes(ranges *AWSIPRanges, ipv4 bool) []AWSMatch {
+	var all []AWSMatch
+	if ipv4 {
+		for _, prefix := range ranges.Prefixes {
+			all = append(all, AWSMatch{
+				Prefix:             prefix.IPPrefix,
+				Region:             prefix.Region,
+				Service:            prefix.Service,
+				NetworkBorderGroup: prefix.NetworkBorderGroup,
+			})
+		}
+	} else {
+		for _, prefix := range ranges.IPv6Prefixes {
+			all = append(all, AWSMatch{
+				Prefix:             prefix.IPv6Prefix,
+				Region:             prefix.Region,
+				Service:            prefix.Service,
+				NetworkBorderGroup: prefix.NetworkBorderGroup,
+			})
+		}
+	}
+	return all
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const replHelp = `Type an IP, a hostname, or an IP range to look it up. Several targets can be
+given on the same line, separated by spaces.
+
+Commands:
+  describe <SERVICE>  explain what a service name means
+  reload              load the AWS IP ranges again
+  help                show this help
+  exit, quit          leave (Ctrl-D works too)
+`
+
+// runREPL loads the ranges once and then answers lookups interactively, which
+// saves downloading and parsing ip-ranges.json for every address during an
+// investigation. When stdin is a terminal, the line can be edited and the
+// up and down arrows go through the history.
+func runREPL(source rangesSource, lookupOpts lookupOptions, tableOpts tableOptions) error {
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	lookupOpts, err = lookupOpts.resolveNames(ranges)
+	if err != nil {
+		return err
+	}
+
+	readLine, out, restore, err := replReader()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	fmt.Fprintf(out, "Loaded %d IPv4 and %d IPv6 prefixes (%s). Type 'help' for help.\n",
+		len(ranges.Prefixes), len(ranges.IPv6Prefixes), ranges.CreateDate)
+
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			fmt.Fprint(out, replHelp)
+		case "reload":
+			// A reload is asked for, so the cached copy isn't good enough.
+			fresh := source
+			fresh.CacheTTL = 0
+			reloaded, err := fresh.load()
+			if err != nil {
+				fmt.Fprintf(out, "Error loading AWS IP ranges: %v\n", err)
+				continue
+			}
+			ranges = reloaded
+			fmt.Fprintf(out, "Loaded %d IPv4 and %d IPv6 prefixes (%s).\n",
+				len(ranges.Prefixes), len(ranges.IPv6Prefixes), ranges.CreateDate)
+		case "describe":
+			for _, svc := range fields[1:] {
+				svc = strings.ToUpper(svc)
+				info := describeService(svc)
+				fmt.Fprintf(out, "%s: %s\n  %s\n", svc, info.Description, info.DocsURL)
+			}
+		default:
+			var results []Result
+			for _, input := range fields {
+				result, err := lookupInput(context.Background(), input, ranges, lookupOpts, out)
+				if err != nil {
+					fmt.Fprintf(out, "Error: %v\n", err)
+					continue
+				}
+				results = append(results, result)
+			}
+			if len(results) > 0 {
+				printResults(out, out, results, ranges, tableOpts)
+			}
+		}
+	}
+}
+
+// replReader returns a function reading one line at a time. On a terminal,
+// the terminal is put in raw mode so that x/term can provide line editing and
+// history; restore must be called to put it back in its original state.
+func replReader() (readLine func() (string, error), out io.Writer, restore func(), err error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		readLine = func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+		return readLine, os.Stdout, func() {}, nil
+	}
+
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "awswhois> ")
+	restore = func() { term.Restore(fd, oldState) }
+	return t.ReadLine, t, restore, nil
+}
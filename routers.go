@@ -0,0 +1,235 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+)
+
+// wildcardMask returns the Cisco wildcard mask of an IPv4 prefix, e.g.
+// 0.3.255.255 for a /14.
+func wildcardMask(p netip.Prefix) string {
+	mask := uint32(0xffffffff)
+	if p.Bits() > 0 {
+		mask = ^(uint32(0xffffffff) << (32 - p.Bits()))
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", mask>>24, mask>>16&0xff, mask>>8&0xff, mask&0xff)
+}
+
+// exportCiscoACL writes an IOS extended access list, and an IPv6 one when
+// there are IPv6 prefixes, ready to be pasted in configuration mode.
+func exportCiscoACL(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export cisco-acl", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	name := fs.String("name", "AWS", "name of the access list; the IPv6 one gets a -V6 suffix")
+	action := fs.String("action", "permit", "what to do with the traffic to or from AWS (permit, deny)")
+	direction := fs.String("direction", "dst", "whether the AWS prefixes are the destination or the source of the traffic (dst, src)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *action != "permit" && *action != "deny" {
+		return usagef("unknown --action %q, must be permit or deny", *action)
+	}
+	if *direction != "dst" && *direction != "src" {
+		return usagef("unknown --direction %q, must be dst or src", *direction)
+	}
+
+	prefixes, err := req.prefixes()
+	if err != nil {
+		return err
+	}
+	entry := func(proto, aws string) string {
+		if *direction == "src" {
+			return fmt.Sprintf(" %s %s %s any", *action, proto, aws)
+		}
+		return fmt.Sprintf(" %s %s any %s", *action, proto, aws)
+	}
+
+	var v4, v6 []string
+	for _, p := range prefixes {
+		switch {
+		case p.Addr().Is6():
+			v6 = append(v6, entry("ipv6", p.String()))
+		case p.Bits() == 32:
+			v4 = append(v4, entry("ip", "host "+p.Addr().String()))
+		default:
+			v4 = append(v4, entry("ip", p.Addr().String()+" "+wildcardMask(p)))
+		}
+	}
+	fmt.Fprintf(out, "! %s\n", req.header())
+	if len(v4) > 0 {
+		fmt.Fprintf(out, "ip access-list extended %s\n", *name)
+		for _, line := range v4 {
+			fmt.Fprintln(out, line)
+		}
+	}
+	if len(v6) > 0 {
+		fmt.Fprintf(out, "ipv6 access-list %s-V6\n", *name)
+		for _, line := range v6 {
+			fmt.Fprintln(out, line)
+		}
+	}
+	return nil
+}
+
+// exportJunosPrefixList writes a Junos prefix-list, in the curly-brace form of
+// "show configuration" or, with --set, as set commands.
+func exportJunosPrefixList(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export junos-prefix-list", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	name := fs.String("name", "aws", "name of the prefix-list")
+	set := fs.Bool("set", false, "write set commands instead of the curly-brace form")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	prefixes, err := req.prefixes()
+	if err != nil {
+		return err
+	}
+	if *set {
+		fmt.Fprintf(out, "# %s\n", req.header())
+		fmt.Fprintf(out, "delete policy-options prefix-list %s\n", *name)
+		for _, p := range prefixes {
+			fmt.Fprintf(out, "set policy-options prefix-list %s %s\n", *name, p)
+		}
+		return nil
+	}
+	fmt.Fprintf(out, "/* %s */\n", req.header())
+	fmt.Fprintf(out, "policy-options {\n    replace: prefix-list %s {\n", *name)
+	for _, p := range prefixes {
+		fmt.Fprintf(out, "        %s;\n", p)
+	}
+	fmt.Fprintf(out, "    }\n}\n")
+	return nil
+}
+
+// exportBIRD writes BIRD 2 prefix sets, aws_v4 and aws_v6 by default, to be
+// used in filters, or with --via static routes towards a gateway or an
+// interface.
+func exportBIRD(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export bird", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	name := fs.String("name", "aws", "prefix of the names of the sets, suffixed with _v4 and _v6")
+	via := fs.String("via", "", "write static routes via this gateway address or, when it isn't an address, this interface, instead of sets")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	prefixes, err := req.prefixes()
+	if err != nil {
+		return err
+	}
+	v4, v6 := splitFamilies(prefixes)
+	fmt.Fprintf(out, "# %s\n", req.header())
+
+	if *via != "" {
+		v4, v6 = routableFamilies(*via, v4, v6)
+		nextHop := fmt.Sprintf("via %q", *via)
+		if _, err := netip.ParseAddr(*via); err == nil {
+			nextHop = "via " + *via
+		}
+		for _, family := range []struct {
+			channel  string
+			prefixes []netip.Prefix
+		}{{"ipv4", v4}, {"ipv6", v6}} {
+			if len(family.prefixes) == 0 {
+				continue
+			}
+			fmt.Fprintf(out, "protocol static %s_%s {\n\t%s;\n", *name, family.channel, family.channel)
+			for _, p := range family.prefixes {
+				fmt.Fprintf(out, "\troute %s %s;\n", p, nextHop)
+			}
+			fmt.Fprintf(out, "}\n")
+		}
+		return nil
+	}
+
+	for _, set := range []struct {
+		suffix   string
+		prefixes []netip.Prefix
+	}{{"_v4", v4}, {"_v6", v6}} {
+		if len(set.prefixes) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "define %s%s = [\n", *name, set.suffix)
+		for i, p := range set.prefixes {
+			sep := ","
+			if i == len(set.prefixes)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(out, "\t%s%s\n", p, sep)
+		}
+		fmt.Fprintf(out, "];\n")
+	}
+	return nil
+}
+
+// exportFRR writes FRR prefix-lists, for route-maps, or with --via static
+// routes, in the syntax of vtysh configuration mode.
+func exportFRR(args []string, source rangesSource, opts lookupOptions, out io.Writer) error {
+	fs := flag.NewFlagSet("export frr", flag.ContinueOnError)
+	req := newExportRequest(fs, source, opts, true)
+	name := fs.String("name", "AWS", "name of the prefix-lists; the IPv6 one gets a -V6 suffix")
+	via := fs.String("via", "", "write static routes via this gateway address or interface instead of prefix-lists")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	prefixes, err := req.prefixes()
+	if err != nil {
+		return err
+	}
+	v4, v6 := splitFamilies(prefixes)
+	fmt.Fprintf(out, "! %s\n", req.header())
+
+	if *via != "" {
+		v4, v6 = routableFamilies(*via, v4, v6)
+		for _, p := range v4 {
+			fmt.Fprintf(out, "ip route %s %s\n", p, *via)
+		}
+		for _, p := range v6 {
+			fmt.Fprintf(out, "ipv6 route %s %s\n", p, *via)
+		}
+		return nil
+	}
+
+	for i, p := range v4 {
+		fmt.Fprintf(out, "ip prefix-list %s seq %d permit %s\n", *name, (i+1)*5, p)
+	}
+	for i, p := range v6 {
+		fmt.Fprintf(out, "ipv6 prefix-list %s-V6 seq %d permit %s\n", *name, (i+1)*5, p)
+	}
+	return nil
+}
+
+// splitFamilies splits the prefixes into the IPv4 and the IPv6 ones.
+func splitFamilies(prefixes []netip.Prefix) (v4, v6 []netip.Prefix) {
+	for _, p := range prefixes {
+		if p.Addr().Is4() {
+			v4 = append(v4, p)
+		} else {
+			v6 = append(v6, p)
+		}
+	}
+	return v4, v6
+}
+
+// routableFamilies drops the prefixes that can't be routed via the gateway,
+// e.g. the IPv6 ones when it's an IPv4 address. An interface can take both.
+func routableFamilies(via string, v4, v6 []netip.Prefix) ([]netip.Prefix, []netip.Prefix) {
+	gw, err := netip.ParseAddr(via)
+	switch {
+	case err != nil:
+		return v4, v6
+	case gw.Is4() && len(v6) > 0:
+		slog.Warn("skipping the IPv6 prefixes, which can't be routed via an IPv4 gateway", "via", via, "prefixes", len(v6))
+		return v4, nil
+	case gw.Is6() && len(v4) > 0:
+		slog.Warn("skipping the IPv4 prefixes, which can't be routed via an IPv6 gateway", "via", via, "prefixes", len(v4))
+		return nil, v6
+	}
+	return v4, v6
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// webAssets holds the single-page UI served at "/".
+//
+//go:embed web
+var webAssets embed.FS
+
+// The timeouts of the HTTP server, so that slow clients can't hold
+// connections open forever. A lookup is bounded by --resolve-timeout, well
+// within serverWriteTimeout.
+const (
+	serverReadHeaderTimeout = 10 * time.Second
+	serverReadTimeout       = 30 * time.Second
+	serverWriteTimeout      = time.Minute
+	serverIdleTimeout       = 2 * time.Minute
+)
+
+// maxPrefixResults caps the number of prefixes returned by /api/prefixes so
+// that an unfiltered request doesn't send the whole file to the browser.
+const maxPrefixResults = 500
+
+// server answers lookups over HTTP for the people who don't use the CLI. The
+// ranges are refreshed every interval; the previous version is kept so that
+// the changes between the two can be shown.
+type server struct {
+	source   rangesSource
+	opts     lookupOptions
+	interval time.Duration
+	stats    *statsdClient
+
+	mu       sync.RWMutex
+	ranges   *AWSIPRanges
+	previous *AWSIPRanges
+}
+
+func newServer(source rangesSource, interval time.Duration, opts lookupOptions) *server {
+	return &server{source: source, interval: interval, opts: opts}
+}
+
+// run loads the ranges and serves until the listener fails.
+func (s *server) run(addr string) error {
+	ranges, err := s.source.load()
+	if err != nil {
+		return err
+	}
+	if s.opts, err = s.opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	s.ranges = ranges
+	s.stats.RecordDataAge(ranges)
+	go s.refresh()
+
+	slog.Info("serving", "addr", addr)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+	return srv.ListenAndServe()
+}
+
+func (s *server) refresh() {
+	for range time.Tick(s.interval) {
+		ranges, err := s.source.load()
+		if err != nil {
+			slog.Error("loading AWS IP ranges", "err", err)
+			continue
+		}
+		s.stats.RecordDataAge(ranges)
+		// The feeds and the excluded prefixes may have changed even when
+		// the AWS ranges haven't, so the new ranges are always swapped in;
+		// only a new syncToken makes the current ones the previous version.
+		s.mu.Lock()
+		if ranges.SyncToken != s.ranges.SyncToken {
+			s.previous = s.ranges
+			slog.Info("AWS IP ranges changed", "syncToken", ranges.SyncToken, "createDate", ranges.CreateDate)
+		}
+		s.ranges = ranges
+		s.mu.Unlock()
+	}
+}
+
+func (s *server) current() (ranges, previous *AWSIPRanges) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ranges, s.previous
+}
+
+func (s *server) handler() http.Handler {
+	web, _ := fs.Sub(webAssets, "web")
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(web))
+	mux.HandleFunc("GET /api/lookup", s.handleLookup)
+	mux.HandleFunc("GET /api/prefixes", s.handlePrefixes)
+	mux.HandleFunc("GET /api/diff", s.handleDiff)
+	return traceRequests(mux)
+}
+
+// traceRequests starts a span for each request so that the lookup, resolve
+// and match spans of a request are grouped under it.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("http.request.method", r.Method), attribute.String("url.path", r.URL.Path)))
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// apiMatch is a matched or listed prefix as returned by the API.
+type apiMatch struct {
+	Prefix             string          `json:"prefix"`
+	Region             string          `json:"region"`
+	Services           string          `json:"services"`
+	NetworkBorderGroup string          `json:"network_border_group"`
+	ZoneType           ZoneType        `json:"zone_type"`
+	Zone               string          `json:"zone"`
+	Location           *RegionLocation `json:"location,omitempty"`
+	Feed               string          `json:"feed,omitempty"`
+}
+
+type apiSubject struct {
+	Label   string     `json:"label"`
+	Matches []apiMatch `json:"matches"`
+}
+
+type apiLookup struct {
+	Target   string       `json:"target"`
+	Kind     Kind         `json:"kind"`
+	Subjects []apiSubject `json:"subjects"`
+}
+
+func (s *server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	input := strings.TrimSpace(r.URL.Query().Get("target"))
+	if input == "" {
+		writeError(w, http.StatusBadRequest, "missing target parameter")
+		return
+	}
+
+	ranges, _ := s.current()
+	result, err := lookupInput(r.Context(), input, ranges, s.opts, io.Discard)
+	if err != nil {
+		s.stats.RecordLookup(Result{Err: err})
+		writeError(w, lookupErrorStatus(err), err.Error())
+		return
+	}
+	s.stats.RecordLookup(result)
+
+	resp := apiLookup{Target: input, Kind: result.Target.Kind, Subjects: []apiSubject{}}
+	for _, subject := range result.Subjects {
+		matches := []apiMatch{}
+		for _, group := range groupMatches(slices.Concat(subject.Matches, subject.Custom)) {
+			matches = append(matches, newAPIMatch(group))
+		}
+		resp.Subjects = append(resp.Subjects, apiSubject{Label: subject.Label, Matches: matches})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// lookupErrorStatus returns the HTTP status of a failed lookup: an input
+// that isn't a target is the client's fault, but a hostname that DNS can't
+// resolve, or not in time, is an upstream failure.
+func lookupErrorStatus(err error) int {
+	switch errorCode(err) {
+	case errCodeResolve:
+		return http.StatusBadGateway
+	case errCodeTimeout:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+type apiPrefixes struct {
+	CreateDate string     `json:"create_date"`
+	Total      int        `json:"total"`
+	Prefixes   []apiMatch `json:"prefixes"`
+}
+
+// handlePrefixes lists the prefixes, optionally filtered by region, service,
+// zone type, IP version, or a substring of the prefix.
+func (s *server) handlePrefixes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	region, service, zoneType, search := q.Get("region"), strings.ToUpper(q.Get("service")), q.Get("zone_type"), q.Get("q")
+	if zoneType != "" {
+		if _, err := parseZoneType(zoneType); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	ranges, _ := s.current()
+	resp := apiPrefixes{CreateDate: ranges.CreateDate, Prefixes: []apiMatch{}}
+	for _, group := range rangesPrefixes(ranges, q.Get("version")) {
+		switch {
+		case region != "" && group.Region != region,
+			service != "" && !slices.Contains(strings.Split(group.Services, ","), service),
+			zoneType != "" && string(group.ZoneType) != zoneType,
+			search != "" && !strings.Contains(group.Prefix, search):
+			continue
+		}
+		resp.Total++
+		if len(resp.Prefixes) < maxPrefixResults {
+			resp.Prefixes = append(resp.Prefixes, newAPIMatch(group))
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type apiDiff struct {
+	From    string     `json:"from,omitempty"`
+	To      string     `json:"to"`
+	Added   []apiMatch `json:"added"`
+	Removed []apiMatch `json:"removed"`
+}
+
+// handleDiff returns the prefixes added and removed by the last change of the
+// ranges seen since the server started.
+func (s *server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	ranges, previous := s.current()
+	resp := apiDiff{To: ranges.CreateDate, Added: []apiMatch{}, Removed: []apiMatch{}}
+	if previous == nil {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	resp.From = previous.CreateDate
+
+	added, removed := diffPrefixes(previous, ranges, lookupOptions{})
+	for _, group := range added {
+		resp.Added = append(resp.Added, newAPIMatch(group))
+	}
+	for _, group := range removed {
+		resp.Removed = append(resp.Removed, newAPIMatch(group))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// rangesPrefixes returns the prefixes of the given IP version ("4", "6", or
+// both when empty) with their services grouped.
+func rangesPrefixes(ranges *AWSIPRanges, version string) []GroupedMatch {
+	var matches []AWSMatch
+	if version != "6" {
+		matches = append(matches, allPrefixes(ranges, true)...)
+	}
+	if version != "4" {
+		matches = append(matches, allPrefixes(ranges, false)...)
+	}
+	return groupMatches(matches)
+}
+
+func newAPIMatch(group GroupedMatch) apiMatch {
+	return apiMatch{
+		Prefix:             group.Prefix,
+		Region:             group.Region,
+		Services:           group.Services,
+		NetworkBorderGroup: group.NetworkBorderGroup,
+		ZoneType:           group.ZoneType,
+		Zone:               zoneLabel(group),
+		Location:           regionLocation(group.Region),
+		Feed:               group.Feed,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleLookupStatus(t *testing.T) {
+	s := &server{ranges: &AWSIPRanges{}}
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{name: "IP", target: "192.0.2.1", want: http.StatusOK},
+		{name: "missing target", target: "", want: http.StatusBadRequest},
+		{name: "not a target", target: "3.4.12.4/99", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/lookup?target="+tt.target, nil))
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestLookupErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&lookupError{Code: errCodeParse, Input: "3.4.12.4/99", Err: errors.New("bad prefix")}, http.StatusBadRequest},
+		{&lookupError{Code: errCodeResolve, Input: "nosuch.example", Err: errors.New("no such host")}, http.StatusBadGateway},
+		{&lookupError{Code: errCodeTimeout, Input: "slow.example", Err: errResolveTimeout}, http.StatusServiceUnavailable},
+		{fmt.Errorf("wrapped: %w", &lookupError{Code: errCodeResolve, Input: "nosuch.example", Err: errors.New("no such host")}), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		if got := lookupErrorStatus(tt.err); got != tt.want {
+			t.Errorf("lookupErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+// ServiceInfo describes one of the service tokens found in the "service"
+// field of ip-ranges.json.
+type ServiceInfo struct {
+	Description string
+	DocsURL     string
+}
+
+// serviceCatalog maps the service tokens used in ip-ranges.json to a human
+// description. The tokens are documented (tersely) at
+// https://docs.aws.amazon.com/vpc/latest/userguide/aws-ip-ranges.html.
+var serviceCatalog = map[string]ServiceInfo{
+	"AMAZON": {
+		Description: "Any Amazon-owned address space; other services are carved out of it",
+		DocsURL:     "https://docs.aws.amazon.com/vpc/latest/userguide/aws-ip-ranges.html",
+	},
+	"AMAZON_APPFLOW": {
+		Description: "Amazon AppFlow data transfers to and from SaaS applications",
+		DocsURL:     "https://docs.aws.amazon.com/appflow/",
+	},
+	"AMAZON_CONNECT": {
+		Description: "Amazon Connect contact center (softphone and media traffic)",
+		DocsURL:     "https://docs.aws.amazon.com/connect/",
+	},
+	"API_GATEWAY": {
+		Description: "Amazon API Gateway endpoints",
+		DocsURL:     "https://docs.aws.amazon.com/apigateway/",
+	},
+	"CHIME_MEETINGS": {
+		Description: "Amazon Chime meetings media and signaling",
+		DocsURL:     "https://docs.aws.amazon.com/chime/",
+	},
+	"CHIME_VOICECONNECTOR": {
+		Description: "Amazon Chime Voice Connector SIP trunking",
+		DocsURL:     "https://docs.aws.amazon.com/chime/",
+	},
+	"CLOUD9": {
+		Description: "AWS Cloud9 IDE environments connecting over SSH",
+		DocsURL:     "https://docs.aws.amazon.com/cloud9/",
+	},
+	"CLOUDFRONT": {
+		Description: "Amazon CloudFront edge locations serving viewer traffic",
+		DocsURL:     "https://docs.aws.amazon.com/cloudfront/",
+	},
+	"CLOUDFRONT_ORIGIN_FACING": {
+		Description: "Addresses CloudFront uses to connect to your origins",
+		DocsURL:     "https://docs.aws.amazon.com/cloudfront/",
+	},
+	"CODEBUILD": {
+		Description: "AWS CodeBuild build hosts",
+		DocsURL:     "https://docs.aws.amazon.com/codebuild/",
+	},
+	"DYNAMODB": {
+		Description: "Amazon DynamoDB endpoints",
+		DocsURL:     "https://docs.aws.amazon.com/dynamodb/",
+	},
+	"EBS": {
+		Description: "Amazon EBS direct APIs",
+		DocsURL:     "https://docs.aws.amazon.com/ebs/",
+	},
+	"EC2": {
+		Description: "Amazon EC2 instances, including public and Elastic IPs",
+		DocsURL:     "https://docs.aws.amazon.com/ec2/",
+	},
+	"EC2_INSTANCE_CONNECT": {
+		Description: "EC2 Instance Connect service connecting to instances over SSH",
+		DocsURL:     "https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/connect-linux-inst-eic.html",
+	},
+	"GLOBALACCELERATOR": {
+		Description: "AWS Global Accelerator anycast addresses",
+		DocsURL:     "https://docs.aws.amazon.com/global-accelerator/",
+	},
+	"IVS_REALTIME": {
+		Description: "Amazon IVS real-time streaming",
+		DocsURL:     "https://docs.aws.amazon.com/ivs/",
+	},
+	"KINESIS_VIDEO_STREAMS": {
+		Description: "Amazon Kinesis Video Streams endpoints",
+		DocsURL:     "https://docs.aws.amazon.com/kinesisvideostreams/",
+	},
+	"MEDIA_PACKAGE_V2": {
+		Description: "AWS Elemental MediaPackage v2 origin traffic",
+		DocsURL:     "https://docs.aws.amazon.com/mediapackage/",
+	},
+	"ROUTE53": {
+		Description: "Amazon Route 53 authoritative DNS servers",
+		DocsURL:     "https://docs.aws.amazon.com/route53/",
+	},
+	"ROUTE53_HEALTHCHECKS": {
+		Description: "Route 53 health checkers probing your endpoints",
+		DocsURL:     "https://docs.aws.amazon.com/Route53/latest/DeveloperGuide/dns-failover.html",
+	},
+	"ROUTE53_HEALTHCHECKS_PUBLISHING": {
+		Description: "Route 53 health checkers publishing their results",
+		DocsURL:     "https://docs.aws.amazon.com/Route53/latest/DeveloperGuide/dns-failover.html",
+	},
+	"ROUTE53_RESOLVER": {
+		Description: "Route 53 Resolver endpoints",
+		DocsURL:     "https://docs.aws.amazon.com/route53/",
+	},
+	"S3": {
+		Description: "Amazon S3 endpoints",
+		DocsURL:     "https://docs.aws.amazon.com/s3/",
+	},
+	"WORKSPACES_GATEWAYS": {
+		Description: "Amazon WorkSpaces streaming gateways",
+		DocsURL:     "https://docs.aws.amazon.com/workspaces/",
+	},
+}
+
+// describeService returns the catalog entry for the given service token. When
+// the token is unknown (AWS adds new ones from time to time), a placeholder is
+// returned instead.
+func describeService(service string) ServiceInfo {
+	if info, ok := serviceCatalog[service]; ok {
+		return info
+	}
+	return ServiceInfo{Description: "(no description available)", DocsURL: "-"}
+}
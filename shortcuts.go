@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultShortcutRegion is used for regional services when the shortcut
+// doesn't name a region, e.g. "@dynamodb".
+const defaultShortcutRegion = "us-east-1"
+
+// globalEndpoints lists the services that have a single endpoint for the
+// whole partition rather than one per region.
+var globalEndpoints = map[string]string{
+	"cloudfront":        "cloudfront.amazonaws.com",
+	"globalaccelerator": "globalaccelerator.amazonaws.com",
+	"iam":               "iam.amazonaws.com",
+	"organizations":     "organizations.us-east-1.amazonaws.com",
+	"route53":           "route53.amazonaws.com",
+	"s3":                "s3.amazonaws.com",
+}
+
+// expandShortcut turns pseudo-targets such as "@s3.eu-west-1", "@cloudfront"
+// or "@dynamodb.us-east-1" into the hostname of the corresponding AWS endpoint.
+func expandShortcut(shortcut string) (string, error) {
+	name := strings.ToLower(strings.TrimPrefix(shortcut, "@"))
+	service, region, hasRegion := strings.Cut(name, ".")
+	if service == "" || (hasRegion && region == "") {
+		return "", fmt.Errorf("invalid shortcut %q, expected @service or @service.region", shortcut)
+	}
+
+	if !hasRegion {
+		if host, ok := globalEndpoints[service]; ok {
+			return host, nil
+		}
+		region = defaultShortcutRegion
+	}
+
+	domain := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("%s.%s.%s", service, region, domain), nil
+}
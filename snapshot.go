@@ -0,0 +1,36 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// snapshotBody is a copy of ip-ranges.json taken when the binary was built,
+// for --offline. The release workflow runs go generate to refresh it before
+// building, and commits it back so that go install gets a recent one too.
+//
+//go:generate curl -fsSL -o snapshot/ip-ranges.json https://ip-ranges.amazonaws.com/ip-ranges.json
+//go:embed snapshot/ip-ranges.json
+var snapshotBody []byte
+
+// loadSnapshot returns the ranges embedded in the binary. Since they only get
+// older, their createDate is logged so that users know how stale they are.
+func loadSnapshot() (*AWSIPRanges, error) {
+	ranges := AWSIPRanges{Embedded: true}
+	if err := json.Unmarshal(snapshotBody, &ranges); err != nil {
+		return nil, fmt.Errorf("embedded snapshot: %w", err)
+	}
+	if ranges.CreateDate == "" || len(ranges.Prefixes) == 0 && len(ranges.IPv6Prefixes) == 0 {
+		return nil, errors.New("this binary was built without a snapshot of the AWS IP ranges, run 'go generate' before building it")
+	}
+	age := "unknown"
+	if created, err := time.Parse(createDateLayout, ranges.CreateDate); err == nil {
+		age = fmt.Sprintf("%d days", int(time.Since(created).Hours()/24))
+	}
+	slog.Warn("using the AWS IP ranges embedded in the binary", "createDate", ranges.CreateDate, "age", age)
+	return &ranges, nil
+}
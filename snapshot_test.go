@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestEmbeddedSnapshot checks the snapshot embedded in the binary, which is
+// empty in the tree until the release workflow runs go generate.
+func TestEmbeddedSnapshot(t *testing.T) {
+	var ranges AWSIPRanges
+	if err := json.Unmarshal(snapshotBody, &ranges); err != nil {
+		t.Fatalf("snapshot/ip-ranges.json doesn't parse: %v", err)
+	}
+	if ranges.CreateDate == "" {
+		t.Skip("snapshot/ip-ranges.json is empty, run 'go generate' to fill it")
+	}
+	got, err := loadSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Prefixes) == 0 || len(got.IPv6Prefixes) == 0 {
+		t.Errorf("got %d IPv4 and %d IPv6 prefixes, want both", len(got.Prefixes), len(got.IPv6Prefixes))
+	}
+	if !got.Embedded {
+		t.Error("the ranges aren't marked as embedded")
+	}
+}
+
+func TestLoadSnapshot(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+		wantErr  string
+	}{
+		{
+			name:     "snapshot",
+			snapshot: `{"syncToken":"1714640000","createDate":"2024-05-02-09-00-00","prefixes":[{"ip_prefix":"3.4.12.4/32","region":"eu-west-1","service":"AMAZON","network_border_group":"eu-west-1"}],"ipv6_prefixes":[]}`,
+		},
+		{
+			name:     "placeholder",
+			snapshot: `{"syncToken":"","createDate":"","prefixes":[],"ipv6_prefixes":[]}`,
+			wantErr:  "built without a snapshot",
+		},
+		{
+			name:     "no prefixes",
+			snapshot: `{"syncToken":"1714640000","createDate":"2024-05-02-09-00-00","prefixes":[],"ipv6_prefixes":[]}`,
+			wantErr:  "built without a snapshot",
+		},
+		{
+			name:     "not JSON",
+			snapshot: `<html>`,
+			wantErr:  "embedded snapshot",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(body []byte) { snapshotBody = body }(snapshotBody)
+			snapshotBody = []byte(tt.snapshot)
+
+			ranges, err := loadSnapshot()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ranges.SyncToken != "1714640000" || len(ranges.Prefixes) != 1 || !ranges.Embedded {
+				t.Errorf("got %+v", ranges)
+			}
+		})
+	}
+}
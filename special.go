@@ -0,0 +1,70 @@
+package main
+
+import "net/netip"
+
+// specialPrefix is a block that is never in the AWS IP ranges because it is
+// private, reserved, or set aside for a protocol.
+type specialPrefix struct {
+	Prefix netip.Prefix
+	Class  string
+}
+
+// specialPrefixes are the blocks of the IANA IPv4 and IPv6 Special-Purpose
+// Address Registries, along with multicast and the reserved 240.0.0.0/4. They
+// overlap: the most specific block wins, e.g. 192.0.0.0/29 over 192.0.0.0/24.
+var specialPrefixes = []specialPrefix{
+	{netip.MustParsePrefix("0.0.0.0/8"), "THIS NETWORK (RFC791)"},
+	{netip.MustParsePrefix("0.0.0.0/32"), "THIS HOST (RFC1122)"},
+	{netip.MustParsePrefix("10.0.0.0/8"), "PRIVATE (RFC1918)"},
+	{netip.MustParsePrefix("100.64.0.0/10"), "CGNAT (RFC6598)"},
+	{netip.MustParsePrefix("127.0.0.0/8"), "LOOPBACK (RFC1122)"},
+	{netip.MustParsePrefix("169.254.0.0/16"), "LINK-LOCAL (RFC3927)"},
+	{netip.MustParsePrefix("172.16.0.0/12"), "PRIVATE (RFC1918)"},
+	{netip.MustParsePrefix("192.0.0.0/24"), "IETF PROTOCOL ASSIGNMENTS (RFC6890)"},
+	{netip.MustParsePrefix("192.0.0.0/29"), "DS-LITE (RFC7335)"},
+	{netip.MustParsePrefix("192.0.2.0/24"), "DOCUMENTATION (TEST-NET-1, RFC5737)"},
+	{netip.MustParsePrefix("192.31.196.0/24"), "AS112 (RFC7535)"},
+	{netip.MustParsePrefix("192.52.193.0/24"), "AMT (RFC7450)"},
+	{netip.MustParsePrefix("192.88.99.0/24"), "6TO4 RELAY ANYCAST (RFC7526)"},
+	{netip.MustParsePrefix("192.168.0.0/16"), "PRIVATE (RFC1918)"},
+	{netip.MustParsePrefix("192.175.48.0/24"), "AS112 (RFC7534)"},
+	{netip.MustParsePrefix("198.18.0.0/15"), "BENCHMARKING (RFC2544)"},
+	{netip.MustParsePrefix("198.51.100.0/24"), "DOCUMENTATION (TEST-NET-2, RFC5737)"},
+	{netip.MustParsePrefix("203.0.113.0/24"), "DOCUMENTATION (TEST-NET-3, RFC5737)"},
+	{netip.MustParsePrefix("224.0.0.0/4"), "MULTICAST (RFC5771)"},
+	{netip.MustParsePrefix("240.0.0.0/4"), "RESERVED (RFC1112)"},
+	{netip.MustParsePrefix("255.255.255.255/32"), "BROADCAST (RFC919)"},
+
+	{netip.MustParsePrefix("::/128"), "UNSPECIFIED (RFC4291)"},
+	{netip.MustParsePrefix("::1/128"), "LOOPBACK (RFC4291)"},
+	{netip.MustParsePrefix("64:ff9b::/96"), "NAT64 (RFC6052)"},
+	{netip.MustParsePrefix("64:ff9b:1::/48"), "LOCAL NAT64 (RFC8215)"},
+	{netip.MustParsePrefix("100::/64"), "DISCARD-ONLY (RFC6666)"},
+	{netip.MustParsePrefix("2001::/23"), "IETF PROTOCOL ASSIGNMENTS (RFC2928)"},
+	{netip.MustParsePrefix("2001::/32"), "TEREDO (RFC4380)"},
+	{netip.MustParsePrefix("2001:2::/48"), "BENCHMARKING (RFC5180)"},
+	{netip.MustParsePrefix("2001:20::/28"), "ORCHIDV2 (RFC7343)"},
+	{netip.MustParsePrefix("2001:db8::/32"), "DOCUMENTATION (RFC3849)"},
+	{netip.MustParsePrefix("2002::/16"), "6TO4 (RFC3056)"},
+	{netip.MustParsePrefix("3fff::/20"), "DOCUMENTATION (RFC9637)"},
+	{netip.MustParsePrefix("fc00::/7"), "PRIVATE (RFC4193)"},
+	{netip.MustParsePrefix("fe80::/10"), "LINK-LOCAL (RFC4291)"},
+	{netip.MustParsePrefix("ff00::/8"), "MULTICAST (RFC4291)"},
+}
+
+// specialClass returns what the IP or CIDR block of the label is when it is in
+// one of the special blocks, and an empty string otherwise. A CIDR block is
+// only classified when it is entirely within the special block.
+func specialClass(label string) string {
+	p, ok := labelPrefix(label)
+	if !ok {
+		return ""
+	}
+	class, bits := "", -1
+	for _, special := range specialPrefixes {
+		if special.Prefix.Bits() <= p.Bits() && special.Prefix.Contains(p.Addr()) && special.Prefix.Bits() > bits {
+			class, bits = special.Class, special.Prefix.Bits()
+		}
+	}
+	return class
+}
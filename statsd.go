@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// createDateLayout is the layout of the createDate field of ip-ranges.json.
+const createDateLayout = "2006-01-02-15-04-05"
+
+// statsdClient sends metrics over UDP using the StatsD line protocol. When
+// tags are given, they are appended the DogStatsD way ("|#key:value").
+// Sending is best-effort: a metric that can't be sent is only logged. All the
+// methods do nothing on a nil client so that callers don't need to check
+// whether --statsd was given.
+type statsdClient struct {
+	conn net.Conn
+	tags string
+}
+
+func newStatsdClient(addr string, tags []string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &statsdClient{conn: conn}
+	if len(tags) > 0 {
+		c.tags = "|#" + strings.Join(tags, ",")
+	}
+	return c, nil
+}
+
+func (c *statsdClient) Count(name string, value int) {
+	c.send(fmt.Sprintf("awswhois.%s:%d|c", name, value))
+}
+
+func (c *statsdClient) Gauge(name string, value float64) {
+	// Exponents such as 1e+07 aren't understood by all StatsD servers.
+	c.send(fmt.Sprintf("awswhois.%s:%s|g", name, strconv.FormatFloat(value, 'f', -1, 64)))
+}
+
+func (c *statsdClient) send(line string) {
+	if c == nil {
+		return
+	}
+	if _, err := c.conn.Write([]byte(line + c.tags)); err != nil {
+		slog.Debug("sending metric", "metric", line, "err", err)
+	}
+}
+
+func (c *statsdClient) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// RecordResults sends the lookup counts and match rate of a batch.
+func (c *statsdClient) RecordResults(results []Result) {
+	matched := c.countResults(results)
+	if len(results) > 0 {
+		c.Gauge("match_rate", float64(matched)/float64(len(results)))
+	}
+}
+
+// RecordLookup sends the counts of a single lookup, such as one answered by
+// serve, for which a match rate would only flap between 0 and 1.
+func (c *statsdClient) RecordLookup(result Result) {
+	c.countResults([]Result{result})
+}
+
+// countResults sends the lookup counts and returns the number of targets in
+// AWS.
+func (c *statsdClient) countResults(results []Result) int {
+	var matched, unmatched, errored, ips, ipsMatched int
+	for _, result := range results {
+		if result.Err != nil {
+			errored++
+			continue
+		}
+		found := false
+		for _, subject := range result.Subjects {
+			ips++
+			if len(subject.Matches) > 0 {
+				ipsMatched++
+				found = true
+			}
+		}
+		if found {
+			matched++
+		} else {
+			unmatched++
+		}
+	}
+
+	c.Count("lookups", len(results))
+	c.Count("lookups.matched", matched)
+	c.Count("lookups.unmatched", unmatched)
+	c.Count("lookups.errors", errored)
+	c.Count("ips", ips)
+	c.Count("ips.matched", ipsMatched)
+	return matched
+}
+
+// RecordDataAge sends how old the AWS IP ranges are, which tells whether the
+// data the answers are based on is fresh.
+func (c *statsdClient) RecordDataAge(ranges *AWSIPRanges) {
+	created, err := time.Parse(createDateLayout, ranges.CreateDate)
+	if err != nil {
+		return
+	}
+	c.Gauge("data_age_seconds", time.Since(created).Seconds())
+}
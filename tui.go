@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// tuiHelp is the last line of the screen.
+const tuiHelp = "↑↓ PgUp PgDn move · Enter resolve a hostname · Esc clear · Ctrl-C quit"
+
+// detailLines is the height of the pane that describes the selected prefix.
+const detailLines = 7
+
+// explorer is the state of "awswhois tui". It only knows about keys and
+// lines of text so that the terminal handling stays in runTUI.
+type explorer struct {
+	ranges *AWSIPRanges
+	opts   lookupOptions
+
+	// all holds every prefix once the lookup filters are applied; rows holds
+	// the ones shown for the current query.
+	all  []GroupedMatch
+	rows []GroupedMatch
+
+	query    string
+	status   string
+	selected int
+	offset   int
+}
+
+func newExplorer(ranges *AWSIPRanges, opts lookupOptions) *explorer {
+	e := &explorer{ranges: ranges, opts: opts}
+	matches, _ := familyPrefixes(ranges, "all", opts)
+	e.all = groupMatches(matches)
+	e.update()
+	return e
+}
+
+// update recomputes the rows after the query changed. An IP or a CIDR block
+// is looked up right away since it needs no DNS; anything else is a list of
+// words that each have to appear in the prefix, the region or its city, the
+// services, the border group, or the zone type.
+func (e *explorer) update() {
+	e.selected, e.offset = 0, 0
+	query := strings.TrimSpace(e.query)
+
+	if addr, err := netip.ParseAddr(query); err == nil {
+		e.rows = groupMatches(e.opts.filter(findAWSMatches(net.IP(addr.AsSlice()), e.ranges)))
+		e.status = fmt.Sprintf("%d prefixes contain %s", len(e.rows), addr)
+		return
+	}
+	if block, err := netip.ParsePrefix(query); err == nil {
+		e.rows = groupMatches(e.opts.filter(findAWSOverlaps(block.Masked(), e.ranges)))
+		e.status = fmt.Sprintf("%d prefixes overlap %s", len(e.rows), block.Masked())
+		return
+	}
+
+	words := strings.Fields(strings.ToLower(query))
+	e.rows = e.rows[:0]
+	for _, group := range e.all {
+		if matchesWords(group, words) {
+			e.rows = append(e.rows, group)
+		}
+	}
+	e.status = fmt.Sprintf("%d of %d prefixes", len(e.rows), len(e.all))
+}
+
+func matchesWords(group GroupedMatch, words []string) bool {
+	haystack := strings.ToLower(strings.Join([]string{group.Prefix, group.Region, group.Services, group.NetworkBorderGroup, zoneLabel(group)}, " "))
+	if loc := regionLocation(group.Region); loc != nil {
+		haystack += " " + strings.ToLower(loc.City)
+	}
+	for _, word := range words {
+		if !strings.Contains(haystack, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve looks the query up the way the command line does, which resolves
+// hostnames, IP ranges, and shortcuts.
+func (e *explorer) resolve() {
+	query := strings.TrimSpace(e.query)
+	if query == "" {
+		return
+	}
+	result, err := lookupInput(context.Background(), query, e.ranges, e.opts, io.Discard)
+	if err != nil {
+		e.status = err.Error()
+		return
+	}
+	var matches []AWSMatch
+	var labels []string
+	for _, subject := range result.Subjects {
+		matches = append(matches, subject.Matches...)
+		labels = append(labels, subject.Label)
+	}
+	e.selected, e.offset = 0, 0
+	e.rows = groupMatches(matches)
+	e.status = fmt.Sprintf("%s: %d prefixes contain %s", query, len(e.rows), strings.Join(labels, ", "))
+}
+
+func (e *explorer) move(delta int) {
+	e.selected = max(0, min(e.selected+delta, len(e.rows)-1))
+}
+
+// handleKeys applies the keys read from the terminal and tells whether to
+// quit. A paste arrives as many keys at once.
+func (e *explorer) handleKeys(keys []byte, pageSize int) (quit bool) {
+	for len(keys) > 0 {
+		switch {
+		case keys[0] == 0x03 || keys[0] == 0x04: // Ctrl-C, Ctrl-D
+			return true
+		case keys[0] == 0x1b && len(keys) >= 3 && (keys[1] == '[' || keys[1] == 'O'):
+			n := 3
+			switch keys[2] {
+			case 'A':
+				e.move(-1)
+			case 'B':
+				e.move(1)
+			case '5', '6':
+				if len(keys) >= 4 && keys[3] == '~' {
+					n = 4
+					if keys[2] == '5' {
+						e.move(-pageSize)
+					} else {
+						e.move(pageSize)
+					}
+				}
+			}
+			keys = keys[n:]
+			continue
+		case keys[0] == 0x1b: // Esc clears the query, or quits when it is empty.
+			if e.query == "" {
+				return true
+			}
+			e.query = ""
+			e.update()
+		case keys[0] == 0x7f || keys[0] == 0x08: // Backspace
+			if _, size := utf8.DecodeLastRuneInString(e.query); size > 0 {
+				e.query = e.query[:len(e.query)-size]
+				e.update()
+			}
+		case keys[0] == 0x15: // Ctrl-U
+			e.query = ""
+			e.update()
+		case keys[0] == '\r' || keys[0] == '\n':
+			e.resolve()
+		default:
+			r, size := utf8.DecodeRune(keys)
+			if unicode.IsPrint(r) {
+				e.query += string(r)
+				e.update()
+			}
+			keys = keys[size:]
+			continue
+		}
+		keys = keys[1:]
+	}
+	return false
+}
+
+// listHeight is how many rows fit between the header lines and the details.
+func listHeight(height int) int {
+	return max(1, height-3-detailLines-1)
+}
+
+// render draws the whole screen: the status and the query, the list of
+// prefixes, the details of the selected one, and the help line.
+func (e *explorer) render(w io.Writer, width, height int) {
+	paint := tableOptions{Color: true}.paint
+	lines := []string{
+		paint(colorBold, fitWidth(fmt.Sprintf("awswhois · ip-ranges.json %s · %s", e.ranges.CreateDate, e.status), width)),
+		fitWidth("> "+e.query, width),
+	}
+
+	widths := []int{len("PREFIX"), len("REGION"), len("SERVICE"), len("BORDER GROUP")}
+	for _, group := range e.rows {
+		for i, s := range []string{group.Prefix, group.Region, group.Services, group.NetworkBorderGroup} {
+			widths[i] = max(widths[i], len(s))
+		}
+	}
+	row := func(cols ...string) string {
+		var b strings.Builder
+		for i, col := range cols[:len(cols)-1] {
+			fmt.Fprintf(&b, "%-*s  ", widths[i], col)
+		}
+		b.WriteString(cols[len(cols)-1])
+		return b.String()
+	}
+	lines = append(lines, paint(colorDim, fitWidth(row("PREFIX", "REGION", "SERVICE", "BORDER GROUP", "ZONE TYPE"), width)))
+
+	rows := listHeight(height)
+	if e.selected < e.offset {
+		e.offset = e.selected
+	}
+	if e.selected >= e.offset+rows {
+		e.offset = e.selected - rows + 1
+	}
+	for i := e.offset; i < e.offset+rows; i++ {
+		if i >= len(e.rows) {
+			lines = append(lines, "")
+			continue
+		}
+		group := e.rows[i]
+		line := fitWidth(row(group.Prefix, group.Region, group.Services, group.NetworkBorderGroup, zoneLabel(group)), width)
+		if i == e.selected {
+			line = "\x1b[7m" + line + colorReset
+		}
+		lines = append(lines, line)
+	}
+
+	details := make([]string, detailLines)
+	if e.selected < len(e.rows) {
+		copy(details, e.details(e.rows[e.selected]))
+	}
+	for _, line := range details {
+		lines = append(lines, fitWidth(line, width))
+	}
+	lines = append(lines, paint(colorDim, fitWidth(tuiHelp, width)))
+
+	// Move home and rewrite every line, clearing what is left of the
+	// previous screen, then put the cursor back at the end of the query.
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines[:min(len(lines), height)] {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line + "\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	fmt.Fprintf(&b, "\x1b[2;%dH", 3+utf8.RuneCountInString(e.query))
+	io.WriteString(w, b.String())
+}
+
+// details describes the selected prefix: where its region is, what its
+// services are, and how it nests with the other prefixes.
+func (e *explorer) details(group GroupedMatch) []string {
+	region := group.Region
+	if loc := regionLocation(group.Region); loc != nil {
+		region = fmt.Sprintf("%s (%s, %s)", group.Region, loc.City, loc.Country)
+	}
+	lines := []string{
+		fmt.Sprintf("%s · %s · %s · %s", group.Prefix, region, group.NetworkBorderGroup, zoneLabel(group)),
+	}
+	for _, svc := range strings.Split(group.Services, ",") {
+		info := describeService(svc)
+		lines = append(lines, fmt.Sprintf("  %s: %s %s", svc, info.Description, info.DocsURL))
+	}
+	if group.ZoneType != ZoneTypeCustom {
+		related := findRelatedPrefixes(group.Prefix, e.ranges)
+		lines = append(lines, fmt.Sprintf("  %d supernets, %d subnets", len(related.Supernets), len(related.Subnets)))
+	}
+	return lines
+}
+
+// fitWidth cuts s to width runes. It must be given text without colors.
+func fitWidth(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:max(0, width-1)]) + "…"
+}
+
+// runTUI implements "awswhois tui", a full-screen explorer of the prefixes:
+// typing filters them by prefix, region, city, service, or zone, an IP or a
+// CIDR block is looked up as it is typed, and Enter resolves a hostname.
+func runTUI(source rangesSource, opts lookupOptions) error {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return errors.New("tui needs a terminal, use repl to read targets from a pipe")
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	e := newExplorer(ranges, opts)
+
+	oldState, err := term.MakeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(in, oldState)
+
+	// The alternate screen keeps the shell's scrollback untouched.
+	fmt.Fprint(os.Stdout, "\x1b[?1049h")
+	defer fmt.Fprint(os.Stdout, "\x1b[?1049l")
+
+	buf := make([]byte, 4096)
+	for {
+		// The size is read again on every key so that a resized terminal
+		// is redrawn at its new size.
+		width, height, err := term.GetSize(out)
+		if err != nil {
+			return err
+		}
+		e.render(os.Stdout, width, height)
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return err
+		}
+		if e.handleKeys(buf[:n], listHeight(height)) {
+			return nil
+		}
+	}
+}
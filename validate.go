@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// errInvalidRanges is returned by runValidate when problems were found.
+var errInvalidRanges = errors.New("invalid ranges")
+
+// rangesValidator collects the problems found in a ranges file.
+type rangesValidator struct {
+	errors, warnings []string
+}
+
+func (v *rangesValidator) errorf(format string, args ...any) {
+	v.errors = append(v.errors, fmt.Sprintf(format, args...))
+}
+
+func (v *rangesValidator) warnf(format string, args ...any) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, args...))
+}
+
+// runValidate implements "awswhois validate", which checks a copy of
+// ip-ranges.json, e.g. from a mirror, before it is trusted.
+func runValidate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	previousFile := fs.String("previous", "", "an earlier copy of the ranges; fail when the new one lost more than --max-shrink of its prefixes or is older")
+	maxShrink := fs.Float64("max-shrink", 5, "with --previous, the percentage of IPv4 or IPv6 prefixes that may disappear")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate [--previous <file>] <ranges.json>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usagef("expected exactly one ranges file")
+	}
+	path := fs.Arg(0)
+
+	var v rangesValidator
+	ranges, err := v.decode(path)
+	if err != nil {
+		return err
+	}
+	v.checkPrefixes(ranges)
+
+	if *previousFile != "" {
+		var prev rangesValidator
+		previous, err := prev.decode(*previousFile)
+		if err != nil {
+			return fmt.Errorf("reading --previous: %w", err)
+		}
+		v.compare(ranges, previous, *maxShrink)
+	}
+
+	for _, msg := range v.errors {
+		fmt.Fprintf(out, "error: %s\n", msg)
+	}
+	for _, msg := range v.warnings {
+		fmt.Fprintf(out, "warning: %s\n", msg)
+	}
+	fmt.Fprintf(out, "%s: %d IPv4 and %d IPv6 prefixes (%s), %d errors, %d warnings\n",
+		path, len(ranges.Prefixes), len(ranges.IPv6Prefixes), ranges.CreateDate, len(v.errors), len(v.warnings))
+	if len(v.errors) > 0 {
+		return errInvalidRanges
+	}
+	return nil
+}
+
+// decode parses the file and checks the top-level fields. Unknown fields are
+// only warned about since AWS adds some from time to time.
+func (v *rangesValidator) decode(path string) (*AWSIPRanges, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges AWSIPRanges
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	strict := json.NewDecoder(bytes.NewReader(data))
+	strict.DisallowUnknownFields()
+	if err := strict.Decode(&AWSIPRanges{}); err != nil {
+		v.warnf("%v", err)
+	}
+
+	if ranges.SyncToken == "" {
+		v.errorf("missing syncToken")
+	}
+	if _, err := time.Parse(createDateLayout, ranges.CreateDate); err != nil {
+		v.errorf("createDate %q isn't of the form YYYY-MM-DD-hh-mm-ss", ranges.CreateDate)
+	}
+	if len(ranges.Prefixes) == 0 {
+		v.errorf("no IPv4 prefixes")
+	}
+	if len(ranges.IPv6Prefixes) == 0 {
+		v.errorf("no IPv6 prefixes")
+	}
+	return &ranges, nil
+}
+
+// checkPrefixes flags the prefixes that can't be parsed, that are in the
+// wrong list, that have host bits set, that appear twice, or whose border
+// group doesn't belong to their region.
+func (v *rangesValidator) checkPrefixes(ranges *AWSIPRanges) {
+	seen := make(map[AWSMatch]bool)
+	check := func(list string, i int, match AWSMatch, ipv4 bool) {
+		where := fmt.Sprintf("%s[%d]", list, i)
+		prefix, err := netip.ParsePrefix(match.Prefix)
+		switch {
+		case err != nil:
+			v.errorf("%s: unparseable prefix %q", where, match.Prefix)
+			return
+		case prefix.Addr().Is4() != ipv4:
+			v.errorf("%s: %s is in the wrong list", where, match.Prefix)
+		case prefix.Masked() != prefix:
+			v.errorf("%s: %s has host bits set, should be %s", where, match.Prefix, prefix.Masked())
+		}
+
+		if match.Region == "" || match.Service == "" || match.NetworkBorderGroup == "" {
+			v.errorf("%s: %s is missing its region, service, or network_border_group", where, match.Prefix)
+		} else if !borderGroupInRegion(match.Region, match.NetworkBorderGroup) {
+			v.errorf("%s: %s has border group %s, which isn't in region %s", where, match.Prefix, match.NetworkBorderGroup, match.Region)
+		}
+
+		if seen[match] {
+			v.errorf("%s: duplicate of %s %s %s %s", where, match.Prefix, match.Region, match.Service, match.NetworkBorderGroup)
+		}
+		seen[match] = true
+	}
+
+	for i, match := range allPrefixes(ranges, true) {
+		check("prefixes", i, match, true)
+	}
+	for i, match := range allPrefixes(ranges, false) {
+		check("ipv6_prefixes", i, match, false)
+	}
+}
+
+// borderGroupInRegion tells whether the border group is the region itself or
+// one of its Local Zones or Wavelength Zones.
+func borderGroupInRegion(region, borderGroup string) bool {
+	if region == "GLOBAL" {
+		return borderGroup == "GLOBAL"
+	}
+	return borderGroup == region || strings.HasPrefix(borderGroup, region+"-")
+}
+
+// compare flags a file that is older than the previous one or that lost more
+// than maxShrink percent of its prefixes, which usually means that a mirror
+// served a truncated or stale copy.
+func (v *rangesValidator) compare(ranges, previous *AWSIPRanges, maxShrink float64) {
+	now, errNow := time.Parse(createDateLayout, ranges.CreateDate)
+	before, errBefore := time.Parse(createDateLayout, previous.CreateDate)
+	if errNow == nil && errBefore == nil && now.Before(before) {
+		v.errorf("createDate %s is older than the previous one, %s", ranges.CreateDate, previous.CreateDate)
+	}
+
+	shrink := func(family string, n, prev int) {
+		if prev == 0 || n >= prev {
+			return
+		}
+		if lost := float64(prev-n) / float64(prev) * 100; lost > maxShrink {
+			v.errorf("%d %s prefixes instead of %d previously, %.1f%% fewer (more than --max-shrink %g%%)", n, family, prev, lost, maxShrink)
+		}
+	}
+	shrink("IPv4", len(ranges.Prefixes), len(previous.Prefixes))
+	shrink("IPv6", len(ranges.IPv6Prefixes), len(previous.IPv6Prefixes))
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// filePollInterval is how often the targets file is checked for changes.
+const filePollInterval = 2 * time.Second
+
+// Classification summarizes where a target lives. Prefixes are left out on
+// purpose: hosts behind round-robin DNS would otherwise be reported as
+// changing all the time.
+type Classification struct {
+	// Summary is e.g. "us-east-1 (AMAZON,EC2)", "not AWS", or "error: ...".
+	Summary string   `json:"summary"`
+	Regions []string `json:"regions,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// InAWS returns true when at least one IP of the target is in AWS.
+func (c Classification) InAWS() bool {
+	return len(c.Regions) > 0
+}
+
+// Change is reported when the classification of a watched target differs
+// from the previous evaluation. Before is nil for targets seen for the first
+// time.
+type Change struct {
+	Time   time.Time       `json:"time"`
+	Target string          `json:"target"`
+	Before *Classification `json:"before,omitempty"`
+	After  Classification  `json:"after"`
+}
+
+// changeSink is given the changes found by each evaluation of the watched
+// targets, e.g. to send notifications.
+type changeSink interface {
+	HandleChanges(ctx context.Context, changes []Change) error
+}
+
+// watcher re-evaluates the targets listed in a file whenever the file changes
+// and every interval, e.g. to notice a vendor migrating to or away from AWS.
+// Each refresh of the ranges may also print the prefixes that changed and
+// rewrite an export.
+type watcher struct {
+	path     string
+	interval time.Duration
+	schedule cron.Schedule
+	source   rangesSource
+	opts     lookupOptions
+	sinks    []changeSink
+	stats    *statsdClient
+
+	// diffRanges prints the prefixes added and removed by each refresh.
+	diffRanges bool
+	// exportArgs are the format and flags of "awswhois export" written to
+	// exportFile on each refresh, e.g. cidr --service S3.
+	exportArgs []string
+	exportFile string
+
+	ranges *AWSIPRanges
+	state  map[string]Classification
+}
+
+func newWatcher(path string, interval time.Duration, source rangesSource, opts lookupOptions) *watcher {
+	return &watcher{
+		path:     path,
+		interval: interval,
+		source:   source,
+		opts:     opts,
+		state:    make(map[string]Classification),
+	}
+}
+
+// run evaluates the targets until an error occurs.
+func (wt *watcher) run() error {
+	var lastMod time.Time
+	var lastEval time.Time
+	for {
+		info, err := os.Stat(wt.path)
+		if err != nil {
+			return err
+		}
+
+		fileChanged := !info.ModTime().Equal(lastMod)
+		due := wt.due(lastEval)
+		if fileChanged || due {
+			// The ranges are only refreshed on the interval; a change to the
+			// targets file alone doesn't warrant downloading them again.
+			if due || wt.ranges == nil {
+				ranges, err := wt.source.load()
+				if err != nil {
+					slog.Error("loading AWS IP ranges", "err", err)
+				} else {
+					if wt.ranges == nil {
+						// The names resolve to themselves from then on.
+						if wt.opts, err = wt.opts.resolveNames(ranges); err != nil {
+							return err
+						}
+					} else if wt.diffRanges && ranges.CreateDate != wt.ranges.CreateDate {
+						printRangesDiff(wt.ranges, ranges, wt.opts)
+					}
+					wt.ranges = ranges
+					if err := wt.export(); err != nil {
+						// An export that can't be run never will be.
+						var usage usageError
+						if errors.As(err, &usage) {
+							return fmt.Errorf("--export: %w", err)
+						}
+						slog.Error("exporting", "file", wt.exportFile, "err", err)
+					}
+				}
+				// Until the ranges are first loaded, there is nothing to
+				// evaluate, so the load is retried on the next poll rather
+				// than on the next interval.
+				if wt.ranges != nil {
+					lastEval = time.Now()
+				}
+			}
+			lastMod = info.ModTime()
+
+			if wt.ranges != nil {
+				changes, err := wt.evaluate()
+				if err != nil {
+					return err
+				}
+				slog.Info("evaluated targets", "file", wt.path, "changes", len(changes))
+				for _, change := range changes {
+					if change.Before != nil {
+						slog.Info("classification changed", "target", change.Target, "before", change.Before.Summary, "after", change.After.Summary)
+					}
+					printChange(change)
+				}
+				wt.recordStats(changes)
+				wt.dispatch(changes)
+			}
+		}
+
+		time.Sleep(filePollInterval)
+	}
+}
+
+// due tells whether the ranges should be refreshed and the targets checked
+// again, either because the interval has elapsed or because the cron
+// schedule, when there is one, has fired since the last evaluation.
+func (wt *watcher) due(lastEval time.Time) bool {
+	if wt.schedule != nil {
+		return !time.Now().Before(wt.schedule.Next(lastEval))
+	}
+	return time.Since(lastEval) >= wt.interval
+}
+
+// export rewrites the export file, if any, with the ranges just loaded.
+func (wt *watcher) export() error {
+	if wt.exportFile == "" {
+		return nil
+	}
+	source := wt.source
+	source.Ranges = wt.ranges
+	var body bytes.Buffer
+	if err := runExport(wt.exportArgs, source, wt.opts, &body); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(wt.exportFile, body.Bytes()); err != nil {
+		return err
+	}
+	slog.Info("exported prefixes", "file", wt.exportFile, "format", wt.exportArgs[0])
+	return nil
+}
+
+// printRangesDiff prints the prefixes added and removed between two versions
+// of the ranges, in the format of printChange.
+func printRangesDiff(before, after *AWSIPRanges, opts lookupOptions) {
+	added, removed := diffPrefixes(before, after, opts)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	fmt.Printf("%s  ip-ranges.json  %s -> %s: %d added, %d removed\n", ts, before.CreateDate, after.CreateDate, len(added), len(removed))
+	for _, group := range removed {
+		fmt.Printf("%s  - %s  %s (%s)\n", ts, group.Prefix, group.Region, group.Services)
+	}
+	for _, group := range added {
+		fmt.Printf("%s  + %s  %s (%s)\n", ts, group.Prefix, group.Region, group.Services)
+	}
+}
+
+// recordStats sends the state of the watched targets to StatsD.
+func (wt *watcher) recordStats(changes []Change) {
+	var inAWS, errored int
+	for _, c := range wt.state {
+		switch {
+		case c.Error != "":
+			errored++
+		case c.InAWS():
+			inAWS++
+		}
+	}
+	wt.stats.Gauge("watch.targets", float64(len(wt.state)))
+	wt.stats.Gauge("watch.targets.in_aws", float64(inAWS))
+	wt.stats.Gauge("watch.targets.errors", float64(errored))
+	wt.stats.Count("watch.changes", len(actualChanges(changes)))
+	wt.stats.RecordDataAge(wt.ranges)
+}
+
+// dispatch hands the changes to each sink. A failing sink is logged but
+// doesn't stop the watch.
+func (wt *watcher) dispatch(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+	for _, sink := range wt.sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := sink.HandleChanges(ctx, changes); err != nil {
+			slog.Error("handling changes", "sink", fmt.Sprintf("%T", sink), "err", err)
+		}
+		cancel()
+	}
+}
+
+// evaluate looks up every target of the file and returns the ones whose
+// classification changed since the last evaluation.
+func (wt *watcher) evaluate() ([]Change, error) {
+	inputs, err := readTargetsFile(wt.path)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var changes []Change
+	for _, input := range inputs {
+		after := classify(input, wt.ranges, wt.opts)
+		before, seen := wt.state[input]
+		if seen && before.Summary == after.Summary {
+			continue
+		}
+		wt.state[input] = after
+
+		change := Change{Time: now, Target: input, After: after}
+		if seen {
+			change.Before = &before
+		}
+		changes = append(changes, change)
+	}
+
+	for input := range wt.state {
+		if !slices.Contains(inputs, input) {
+			delete(wt.state, input)
+		}
+	}
+	return changes, nil
+}
+
+func classify(input string, ranges *AWSIPRanges, opts lookupOptions) Classification {
+	target, err := parseTarget(input)
+	if err != nil {
+		return Classification{Summary: "error: " + err.Error(), Error: err.Error()}
+	}
+	result, err := lookup(context.Background(), target, ranges, opts)
+	if err != nil {
+		return Classification{Summary: "error: " + err.Error(), Error: err.Error()}
+	}
+
+	services := make(map[string][]string)
+	var regions []string
+	for _, subject := range result.Subjects {
+		for _, match := range subject.Matches {
+			if !slices.Contains(regions, match.Region) {
+				regions = append(regions, match.Region)
+			}
+			if !slices.Contains(services[match.Region], match.Service) {
+				services[match.Region] = append(services[match.Region], match.Service)
+			}
+		}
+	}
+	if len(regions) == 0 {
+		return Classification{Summary: "not AWS"}
+	}
+
+	slices.Sort(regions)
+	var parts []string
+	for _, region := range regions {
+		slices.Sort(services[region])
+		parts = append(parts, fmt.Sprintf("%s (%s)", region, strings.Join(services[region], ",")))
+	}
+	return Classification{Summary: strings.Join(parts, ", "), Regions: regions}
+}
+
+func printChange(change Change) {
+	ts := change.Time.UTC().Format(time.RFC3339)
+	if change.Before == nil {
+		fmt.Printf("%s  %s  %s\n", ts, change.Target, change.After.Summary)
+		return
+	}
+	fmt.Printf("%s  %s  %s -> %s\n", ts, change.Target, change.Before.Summary, change.After.Summary)
+}
+
+// readTargetsFile reads the targets listed in a file, see readTargets.
+func readTargetsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines, err := readTargets(f)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, line := range lines {
+		targets = append(targets, line.Text)
+	}
+	return targets, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// dnsRecord is an A, AAAA, or CNAME record of a zone file. Names are fully
+// qualified, without the trailing dot.
+type dnsRecord struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+// dnsClasses are the classes that may precede the type of a record.
+var dnsClasses = []string{"IN", "CH", "HS", "CS"}
+
+// parseZoneFile reads the A, AAAA, and CNAME records of a zone file in the
+// format of RFC 1035, as used by BIND. The origin is the one that relative
+// names are qualified with until a $ORIGIN directive changes it. The other
+// record types are skipped.
+func parseZoneFile(r io.Reader, origin string) ([]dnsRecord, error) {
+	origin = strings.TrimSuffix(origin, ".")
+	qualify := func(name string) string {
+		switch {
+		case name == "@":
+			return origin
+		case strings.HasSuffix(name, "."):
+			return strings.TrimSuffix(name, ".")
+		case origin == "":
+			return name
+		}
+		return name + "." + origin
+	}
+
+	var records []dnsRecord
+	var owner, entry string
+	var depth, start int
+	scanner := bufio.NewScanner(r)
+	for num := 1; scanner.Scan(); num++ {
+		line := stripZoneComment(scanner.Text())
+		// A record may span several lines between parentheses, e.g. the SOA.
+		if depth == 0 {
+			entry, start = line, num
+		} else {
+			entry += " " + line
+		}
+		depth += strings.Count(line, "(") - strings.Count(line, ")")
+		if depth > 0 {
+			continue
+		}
+		depth = 0
+		line = strings.NewReplacer("(", " ", ")", " ").Replace(entry)
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: $ORIGIN without a name", start)
+			}
+			origin = qualify(fields[1])
+			continue
+		case "$TTL":
+			continue
+		case "$INCLUDE", "$GENERATE":
+			slog.Warn("skipping unsupported directive", "line", start, "directive", fields[0])
+			continue
+		}
+
+		// A record that starts with a blank has the owner of the previous
+		// one.
+		if line[0] != ' ' && line[0] != '\t' {
+			owner = qualify(fields[0])
+			fields = fields[1:]
+		} else if owner == "" {
+			return nil, fmt.Errorf("line %d: record without an owner name", start)
+		}
+		// The TTL and the class are optional and come in either order.
+		for len(fields) > 0 && (isTTL(fields[0]) || slices.Contains(dnsClasses, strings.ToUpper(fields[0]))) {
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: record without a type and a value", start)
+		}
+		switch typ := strings.ToUpper(fields[0]); typ {
+		case "A", "AAAA":
+			records = append(records, dnsRecord{Name: owner, Type: typ, Value: fields[1]})
+		case "CNAME":
+			records = append(records, dnsRecord{Name: owner, Type: typ, Value: qualify(fields[1])})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("line %d: unbalanced parentheses", start)
+	}
+	return records, nil
+}
+
+// stripZoneComment removes what follows a ';' outside of a quoted string.
+func stripZoneComment(line string) string {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// isTTL tells whether the field is a TTL, either in seconds or in the BIND
+// form such as 1h30m.
+func isTTL(field string) bool {
+	return field != "" && strings.Trim(strings.ToLower(field), "0123456789smhdw") == "" && field[0] >= '0' && field[0] <= '9'
+}
+
+// zoneOrigin guesses the origin of a zone file from its name, e.g.
+// example.com from example.com.zone or db.example.com.
+func zoneOrigin(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimPrefix(name, "db.")
+	for _, ext := range []string{".zone", ".db", ".hosts"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// runZone implements "awswhois zone", which audits the A, AAAA, and CNAME
+// records of a zone file: the CNAME targets are resolved, and the records are
+// reported grouped by name along with the AWS prefix, region, and service
+// they point at.
+func runZone(args []string, source rangesSource, opts lookupOptions, concurrency int, out, errOut io.Writer) error {
+	fs := flag.NewFlagSet("zone", flag.ContinueOnError)
+	origin := fs.String("origin", "", "the origin of the relative names until a $ORIGIN directive (default: the file name without the .zone extension)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] zone [--origin <domain>] <zone file>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usagef("zone takes a zone file")
+	}
+	if *origin == "" {
+		*origin = zoneOrigin(fs.Arg(0))
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	records, err := parseZoneFile(f, *origin)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+
+	ranges, err := source.load()
+	if err != nil {
+		return fmt.Errorf("loading AWS IP ranges: %w", err)
+	}
+	if opts, err = opts.resolveNames(ranges); err != nil {
+		return err
+	}
+	return writeAudit(out, records, auditLookup(records, concurrency, ranges, opts, errOut))
+}
+
+// auditLookup looks the values of the records up, resolving the hostnames.
+func auditLookup(records []dnsRecord, concurrency int, ranges *AWSIPRanges, opts lookupOptions, errOut io.Writer) func(i int) lookupOutcome {
+	inputs := make([]TargetLine, len(records))
+	for i, record := range records {
+		inputs[i] = TargetLine{Num: i + 1, Text: record.Value}
+	}
+	return lookupAll(context.Background(), inputs, concurrency, ranges, opts, errOut)
+}
+
+// writeAudit writes the records sorted by name, the name being only shown on
+// the first row of its group, with a row per IP they point at, followed by
+// the number of names that point at AWS.
+func writeAudit(out io.Writer, records []dnsRecord, outcome func(i int) lookupOutcome) error {
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return strings.Compare(records[a].Name, records[b].Name) })
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tVALUE\tIP\tPREFIX\tREGION\tSERVICE")
+	names := make(map[string]bool)
+	inAWS := make(map[string]bool)
+	var previous string
+	for _, i := range order {
+		record := records[i]
+		name := record.Name
+		if name == previous {
+			name = ""
+		}
+		previous = record.Name
+		names[record.Name] = true
+
+		o := outcome(i)
+		if o.Err != nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\terror: %v\t\t\n", name, record.Type, record.Value, o.Err)
+			continue
+		}
+		for _, subject := range o.Result.Subjects {
+			prefix, region, service, found := auditCells(subject)
+			if found {
+				inAWS[record.Name] = true
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, record.Type, record.Value, subject.Label, prefix, region, service)
+			name = ""
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "\n%d of %d names point at AWS\n", len(inAWS), len(names))
+	return err
+}
+
+// auditCells returns the prefix, region, and services of the most specific
+// match of the subject, or what it is when it is private or reserved, and
+// whether it is in AWS.
+func auditCells(subject Subject) (prefix, region, service string, inAWS bool) {
+	prefix, region, service = "-", "-", "-"
+	if class := specialClass(subject.Label); class != "" {
+		prefix = class
+	}
+	groups := groupMatches(subject.Matches)
+	if len(groups) == 0 {
+		return prefix, region, service, false
+	}
+	best := groups[len(groups)-1]
+	return best.Prefix, best.Region, best.Services, true
+}
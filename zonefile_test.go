@@ -0,0 +1,144 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseZoneFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		zone    string
+		want    []dnsRecord
+		wantErr string
+	}{
+		{
+			name:   "multi-line SOA",
+			origin: "example.com",
+			zone: `$TTL 3600
+@	IN	SOA	ns1 hostmaster (
+		2024050201 ; serial
+		7200 3600 1209600 3600 )
+@	IN	A	3.4.12.4
+www	IN	CNAME	@
+`,
+			want: []dnsRecord{
+				{Name: "example.com", Type: "A", Value: "3.4.12.4"},
+				{Name: "www.example.com", Type: "CNAME", Value: "example.com"},
+			},
+		},
+		{
+			name:   "owner inherited from the previous record",
+			origin: "example.com.",
+			zone: `www	A	3.4.12.4
+	AAAA	2600:1f18::1
+api	A	52.94.76.1
+`,
+			want: []dnsRecord{
+				{Name: "www.example.com", Type: "A", Value: "3.4.12.4"},
+				{Name: "www.example.com", Type: "AAAA", Value: "2600:1f18::1"},
+				{Name: "api.example.com", Type: "A", Value: "52.94.76.1"},
+			},
+		},
+		{
+			name:   "TTL and class in either order",
+			origin: "example.com",
+			zone: `a 300 IN A 192.0.2.1
+b IN 300 A 192.0.2.2
+c 1h30m A 192.0.2.3
+d in a 192.0.2.4
+`,
+			want: []dnsRecord{
+				{Name: "a.example.com", Type: "A", Value: "192.0.2.1"},
+				{Name: "b.example.com", Type: "A", Value: "192.0.2.2"},
+				{Name: "c.example.com", Type: "A", Value: "192.0.2.3"},
+				{Name: "d.example.com", Type: "A", Value: "192.0.2.4"},
+			},
+		},
+		{
+			name:   "$ORIGIN",
+			origin: "example.com",
+			zone: `a A 192.0.2.1
+$ORIGIN sub.example.com.
+b A 192.0.2.2
+$ORIGIN deeper
+c A 192.0.2.3
+d.example.org. A 192.0.2.4
+e CNAME lb.example.net.
+`,
+			want: []dnsRecord{
+				{Name: "a.example.com", Type: "A", Value: "192.0.2.1"},
+				{Name: "b.sub.example.com", Type: "A", Value: "192.0.2.2"},
+				{Name: "c.deeper.sub.example.com", Type: "A", Value: "192.0.2.3"},
+				{Name: "d.example.org", Type: "A", Value: "192.0.2.4"},
+				{Name: "e.deeper.sub.example.com", Type: "CNAME", Value: "lb.example.net"},
+			},
+		},
+		{
+			name:   "comments and quoted semicolons",
+			origin: "example.com",
+			zone: `; generated by hand
+txt	TXT	"v=spf1; -all" ; the ';' in quotes isn't a comment
+www	A	192.0.2.1 ; was 192.0.2.9
+mail	MX	10 mx.example.com.
+`,
+			want: []dnsRecord{
+				{Name: "www.example.com", Type: "A", Value: "192.0.2.1"},
+			},
+		},
+		{
+			name:    "unbalanced parentheses",
+			origin:  "example.com",
+			zone:    "@ IN SOA ns1 hostmaster ( 1 7200\n3600 1209600 3600\n",
+			wantErr: "line 1: unbalanced parentheses",
+		},
+		{
+			name:    "record without an owner",
+			origin:  "example.com",
+			zone:    "\tA 192.0.2.1\n",
+			wantErr: "line 1: record without an owner name",
+		},
+		{
+			name:    "$ORIGIN without a name",
+			origin:  "example.com",
+			zone:    "www A 192.0.2.1\n$ORIGIN\n",
+			wantErr: "line 2: $ORIGIN without a name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseZoneFile(strings.NewReader(tt.zone), tt.origin)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripZoneComment(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{`www A 192.0.2.1`, `www A 192.0.2.1`},
+		{`www A 192.0.2.1 ; comment`, `www A 192.0.2.1 `},
+		{`; whole line`, ``},
+		{`txt TXT "a;b"`, `txt TXT "a;b"`},
+		{`txt TXT "a;b" ; c;d`, `txt TXT "a;b" `},
+	}
+	for _, tt := range tests {
+		if got := stripZoneComment(tt.line); got != tt.want {
+			t.Errorf("stripZoneComment(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
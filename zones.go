@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ZoneType tells what kind of AWS location a network border group is. The
+// latency and data-residency properties of a Local Zone or Wavelength Zone
+// are quite different from the ones of its parent region.
+type ZoneType string
+
+const (
+	ZoneTypeRegion     ZoneType = "region"
+	ZoneTypeLocalZone  ZoneType = "local-zone"
+	ZoneTypeWavelength ZoneType = "wavelength-zone"
+	ZoneTypeGlobal     ZoneType = "global"
+
+	// ZoneTypeCustom is given to the prefixes of the --feed lists, which
+	// aren't AWS locations at all.
+	ZoneTypeCustom ZoneType = "custom"
+)
+
+var zoneTypes = []ZoneType{ZoneTypeRegion, ZoneTypeLocalZone, ZoneTypeWavelength, ZoneTypeGlobal, ZoneTypeCustom}
+
+// String returns the human-readable name shown in the table.
+func (z ZoneType) String() string {
+	switch z {
+	case ZoneTypeRegion:
+		return "Region"
+	case ZoneTypeLocalZone:
+		return "Local Zone"
+	case ZoneTypeWavelength:
+		return "Wavelength Zone"
+	case ZoneTypeGlobal:
+		return "Global"
+	case ZoneTypeCustom:
+		return "Custom"
+	}
+	return string(z)
+}
+
+func parseZoneType(s string) (ZoneType, error) {
+	for _, z := range zoneTypes {
+		if string(z) == s {
+			return z, nil
+		}
+	}
+
+	var valid []string
+	for _, z := range zoneTypes {
+		valid = append(valid, string(z))
+	}
+	return "", fmt.Errorf("unknown zone type %q, must be one of: %s", s, strings.Join(valid, ", "))
+}
+
+// classifyBorderGroup works out the zone type from the naming scheme AWS uses
+// for network border groups:
+//
+//	us-east-1                 the region itself
+//	us-west-2-lax-1           a Local Zone attached to us-west-2
+//	us-east-1-wl1-bos-wlz-1   a Wavelength Zone attached to us-east-1
+//	GLOBAL                    CloudFront, Route 53 and other global services
+func classifyBorderGroup(region, borderGroup string) ZoneType {
+	switch {
+	case region == "GLOBAL" || borderGroup == "GLOBAL":
+		return ZoneTypeGlobal
+	case borderGroup == region:
+		return ZoneTypeRegion
+	case strings.Contains(borderGroup, "-wl1-") || strings.Contains(borderGroup, "-wlz-"):
+		return ZoneTypeWavelength
+	case strings.HasPrefix(borderGroup, region+"-"):
+		return ZoneTypeLocalZone
+	}
+	return ZoneTypeRegion
+}
+
+// matchZoneType is the zone type of the match, custom for the prefixes of the
+// --feed lists.
+func matchZoneType(match AWSMatch) ZoneType {
+	if match.Feed != "" {
+		return ZoneTypeCustom
+	}
+	return classifyBorderGroup(match.Region, match.NetworkBorderGroup)
+}
+
+// wavelengthCarriers maps the parent region of a Wavelength Zone to the
+// telecommunication carrier whose 5G network hosts it. Wavelength Zones are
+// only offered by a single carrier per country.
+var wavelengthCarriers = map[string]string{
+	"us-east-1":      "Verizon",
+	"us-west-2":      "Verizon",
+	"ca-central-1":   "Bell",
+	"eu-west-2":      "Vodafone",
+	"eu-central-1":   "Vodafone",
+	"ap-northeast-1": "KDDI",
+	"ap-northeast-2": "SK Telecom",
+}
+
+// wavelengthCarrier returns the carrier operating the given Wavelength Zone
+// border group, or an empty string when it isn't known.
+func wavelengthCarrier(borderGroup string) string {
+	region, _, ok := strings.Cut(borderGroup, "-wl1-")
+	if !ok {
+		return ""
+	}
+	return wavelengthCarriers[region]
+}
+
+// zoneLabel is what the ZONE TYPE column shows. For Wavelength Zones, the
+// carrier is appended since the traffic comes from that carrier's mobile
+// network rather than from AWS proper. For custom prefixes, the name of the
+// feed is appended.
+func zoneLabel(group GroupedMatch) string {
+	if group.ZoneType == ZoneTypeCustom {
+		return fmt.Sprintf("%s (%s)", group.ZoneType, group.Feed)
+	}
+	if group.ZoneType == ZoneTypeWavelength {
+		if carrier := wavelengthCarrier(group.NetworkBorderGroup); carrier != "" {
+			return fmt.Sprintf("%s (%s)", group.ZoneType, carrier)
+		}
+	}
+	return group.ZoneType.String()
+}
+
+func excludeZoneType(matches []AWSMatch, zoneType ZoneType) []AWSMatch {
+	var filtered []AWSMatch
+	for _, match := range matches {
+		if matchZoneType(match) != zoneType {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}
+
+func filterByZoneType(matches []AWSMatch, zoneType ZoneType) []AWSMatch {
+	var filtered []AWSMatch
+	for _, match := range matches {
+		if matchZoneType(match) == zoneType {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}